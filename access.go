@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey string
+
+const requestIDKey contextKey = "request-id"
+
+// Incoming ID from proxy is reused only if it's short and plain, as it's written to log and page
+var requestIDExp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Probes are frequent and not useful in access log
+var accessLogSkipped = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().Format("150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// ID of request, assigned by accessLogMiddleware
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// Logger with request ID (and trace ID if traced) field, so entries of a request can be correlated with its access log
+func requestLogger(r *http.Request, logger *Logger) *Logger {
+	fields := Fields{"request-id": RequestID(r)}
+	if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+		fields["trace-id"] = span.TraceID().String()
+	}
+	return logger.With(fields)
+}
+
+// Plain text error with request ID, so family or staff can quote it to support
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if id := RequestID(r); id != "" {
+		message += " (kode: " + id + ")"
+	}
+	http.Error(w, message, code)
+}
+
+// Assign or propagate X-Request-ID, and write one access log entry per request.
+// Queue number is logged as "patient" field, which is hashed by logger
+func accessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDExp.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if accessLogSkipped[r.URL.Path] {
+			return
+		}
+
+		fields := Fields{
+			"request-id":  id,
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      recorder.status,
+			"duration-ms": time.Since(start).Milliseconds(),
+		}
+
+		// Branch and process come from path (board) or query (search)
+		query := r.URL.Query()
+		vars := mux.Vars(r)
+		if branch := vars["branch"] + query.Get("branch"); branch != "" {
+			fields["branch"] = branch
+		}
+		if process := vars["process"] + query.Get("process"); process != "" {
+			fields["process"] = process
+		}
+		if patient := query.Get("qinput1") + query.Get("qinput2") + query.Get("qinput3") + query.Get("qinput4"); patient != "" {
+			fields["patient"] = patient
+		}
+
+		InfoLogger.With(fields).Printf("access")
+	})
+}
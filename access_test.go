@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogMiddleware(t *testing.T) {
+	type Test struct {
+		name     string
+		incoming string
+		keep     bool
+	}
+
+	tests := []Test{
+		{name: "propagated", incoming: "lb-1234.abc", keep: true},
+		{name: "generated", incoming: "", keep: false},
+		{name: "unsafe", incoming: "<script>", keep: false},
+	}
+
+	for _, tt := range tests {
+		var seen string
+		handler := accessLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = RequestID(r)
+			httpError(w, r, "input gagal diproses.", http.StatusInternalServerError)
+		}))
+
+		req := httptest.NewRequest("GET", "/search?branch=kbj&process=opr&qinput1=A&qinput2=0&qinput3=0&qinput4=1", nil)
+		if tt.incoming != "" {
+			req.Header.Set("X-Request-ID", tt.incoming)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		id := w.Header().Get("X-Request-ID")
+		if id == "" || id != seen {
+			t.Errorf("case %v: request ID not available to handler: header %q handler %q", tt.name, id, seen)
+		}
+		if kept := id == tt.incoming; kept != tt.keep {
+			t.Errorf("case %v: wrong propagation: get %q incoming %q", tt.name, id, tt.incoming)
+		}
+		if !strings.Contains(w.Body.String(), "kode: "+id) {
+			t.Errorf("case %v: request ID not shown in error: %q", tt.name, w.Body.String())
+		}
+	}
+}
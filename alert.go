@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Patient staying in a room longer than configured threshold
+type Alert struct {
+	ID             string    `json:"id"`
+	Branch         string    `json:"branch"`
+	Process        string    `json:"process"`
+	Room           string    `json:"room"`
+	PatientID      string    `json:"patient-id"`
+	Since          string    `json:"since"`
+	Minutes        int       `json:"minutes"`
+	Threshold      int       `json:"threshold"`
+	RaisedAt       time.Time `json:"raised-at"`
+	Acknowledged   bool      `json:"acknowledged"`
+	AcknowledgedAt time.Time `json:"acknowledged-at"`
+}
+
+func (alert Alert) Message() string {
+	branchName, _ := AppConfig.getBranchInfo(alert.Branch)
+	return fmt.Sprintf("Pasien %v (%v, %v) berada di %v sejak pk. %v (%v menit, batas %v menit)",
+		alert.PatientID, branchName, ProcessLibMap[alert.Process], alert.Room, alert.Since, alert.Minutes, alert.Threshold)
+}
+
+// Header value, so values from HIS are encoded instead of breaking into new header line
+func (alert Alert) Subject() string {
+	return mime.QEncoding.Encode("UTF-8", "[KMN Antrian] Pasien "+alert.PatientID+" melebihi batas waktu di "+alert.Room)
+}
+
+// Alert IDs are sequence numbers, so "10" comes after "9"
+func (alert Alert) seq() int {
+	n, _ := strconv.Atoi(alert.ID)
+	return n
+}
+
+// Active alerts, keyed by date, branch, process, room and patient. Alert is removed once patient leaves the room
+var alerts = struct {
+	sync.Mutex
+	byKey map[string]*Alert
+	seq   int
+}{byKey: make(map[string]*Alert)}
+
+//========================================================================//
+// ** Alert channels **//
+
+type AlertChannel interface {
+	Name() string
+	Send(alert Alert) error
+}
+
+type logAlertChannel struct{}
+
+func (logAlertChannel) Name() string { return "log" }
+
+func (logAlertChannel) Send(alert Alert) error {
+	// Message contains queue number, so it's logged as hashed field instead
+	WarnLogger.With(Fields{"patient": alert.PatientID, "branch": alert.Branch, "process": alert.Process}).Printf(
+		"alert: patient stays in %v since %v (%v minutes, limit %v minutes)", alert.Room, alert.Since, alert.Minutes, alert.Threshold)
+	return nil
+}
+
+type webhookAlertChannel struct {
+	url    string
+	client *http.Client
+}
+
+func (webhookAlertChannel) Name() string { return "webhook" }
+
+func (c webhookAlertChannel) Send(alert Alert) error {
+	body := map[string]interface{}{
+		"alert":   alert,
+		"message": alert.Message(),
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Post(c.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %v", resp.Status)
+	}
+	return nil
+}
+
+// Sent through local SMTP relay without authentication. Whole exchange is limited by timeout,
+// as hanging relay would otherwise stop alert evaluation
+type emailAlertChannel struct {
+	addr    string
+	from    string
+	to      []string
+	timeout time.Duration
+}
+
+func (emailAlertChannel) Name() string { return "email" }
+
+func (c emailAlertChannel) Send(alert Alert) error {
+	msg := "From: " + c.from + "\r\n" +
+		"To: " + strings.Join(c.to, ", ") + "\r\n" +
+		"Subject: " + alert.Subject() + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		alert.Message() + "\r\n"
+
+	conn, err := net.DialTimeout("tcp", c.addr, c.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	host, _, _ := net.SplitHostPort(c.addr)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Same steps as smtp.SendMail, which has no timeout
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(c.from); err != nil {
+		return err
+	}
+	for _, to := range c.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+var alertChannels []AlertChannel
+
+func initAlertChannels() {
+	alertChannels = nil
+	for _, name := range AppConfig.AlertChannels {
+		switch name {
+		case "log":
+			alertChannels = append(alertChannels, logAlertChannel{})
+		case "webhook":
+			if AppConfig.AlertWebhookURL == "" {
+				WarnLogger.Printf("alert: webhook channel is enabled but ALERT_WEBHOOK_URL is empty. skipped")
+				continue
+			}
+			alertChannels = append(alertChannels, webhookAlertChannel{
+				url:    AppConfig.AlertWebhookURL,
+				client: &http.Client{Timeout: 10 * time.Second},
+			})
+		case "email":
+			if len(AppConfig.AlertEmailTo) == 0 {
+				WarnLogger.Printf("alert: email channel is enabled but ALERT_EMAIL_TO is empty. skipped")
+				continue
+			}
+			alertChannels = append(alertChannels, emailAlertChannel{
+				addr:    AppConfig.AlertSMTPAddr,
+				from:    AppConfig.AlertEmailFrom,
+				to:      AppConfig.AlertEmailTo,
+				timeout: 10 * time.Second,
+			})
+		default:
+			WarnLogger.Printf("alert: unknown channel %v. skipped", name)
+		}
+	}
+}
+
+func sendAlert(alert Alert) {
+	for _, channel := range alertChannels {
+		if err := channel.Send(alert); err != nil {
+			ErrorLogger.Printf("alert: fail to send %v through %v. %v", alert.ID, channel.Name(), err)
+		}
+	}
+}
+
+//========================================================================//
+// ** Evaluator **//
+
+// Periodically check every branch for patients staying too long
+func StartAlertEvaluator() {
+	initAlertChannels()
+
+	go func() {
+		for {
+			for _, branch := range AppConfig.Branches {
+				patients, _, err := LookupBranchQueueLogs(branch.Code)
+				if err != nil {
+					// keep existing alerts until database is reachable again
+					ErrorLogger.Printf("alert: sql query failed for %v(%v). %v", branch.ID, branch.Name, err)
+					continue
+				}
+
+				for _, alert := range EvaluateAlerts(branch.Code, patients, time.Now()) {
+					sendAlert(alert)
+				}
+			}
+
+			time.Sleep(AppConfig.AlertInterval)
+		}
+	}()
+}
+
+// Threshold of each room name in a process at a branch. First non-zero threshold is used for rooms sharing name
+func alertThresholds(branchCode, processCode string) map[string]int {
+	thresholds := make(map[string]int)
+	for _, room := range AppConfig.BranchRoomSet(branchCode, processCode).Rooms {
+		if room.AlertAfter > 0 && thresholds[room.Name] == 0 {
+			thresholds[room.Name] = room.AlertAfter
+		}
+	}
+	return thresholds
+}
+
+// Update active alerts of a branch. Returns newly raised alerts
+func EvaluateAlerts(branchCode string, patients map[string][]PatientLog, now time.Time) []Alert {
+	date := queueDate()
+	current := make(map[string]Alert)
+
+	for _, process := range ProcessLibArr {
+		thresholds := alertThresholds(branchCode, process.Code)
+		if len(thresholds) == 0 {
+			continue
+		}
+
+		occupancy := ConstructOccupancy(patients, branchCode, process.Code, now)
+		for _, room := range occupancy.Rooms {
+			threshold := thresholds[room.Name]
+			if threshold == 0 {
+				continue
+			}
+
+			for _, p := range room.Patients {
+				if p.Minutes < threshold {
+					continue
+				}
+
+				key := strings.Join([]string{date, branchCode, process.Code, room.Name, p.ID}, "|")
+				current[key] = Alert{
+					Branch:    branchCode,
+					Process:   process.Code,
+					Room:      room.Name,
+					PatientID: p.ID,
+					Since:     p.Since,
+					Minutes:   p.Minutes,
+					Threshold: threshold,
+				}
+			}
+		}
+	}
+
+	alerts.Lock()
+	defer alerts.Unlock()
+
+	// Patient left the room (or day changed): alert is resolved
+	for key, alert := range alerts.byKey {
+		if _, exist := current[key]; alert.Branch == branchCode && !exist {
+			delete(alerts.byKey, key)
+		}
+	}
+
+	var raised []Alert
+	for key, alert := range current {
+		if existing, exist := alerts.byKey[key]; exist {
+			existing.Minutes = alert.Minutes
+			continue
+		}
+
+		alerts.seq++
+		alert.ID = strconv.Itoa(alerts.seq)
+		alert.RaisedAt = now
+		alerts.byKey[key] = &alert
+		raised = append(raised, alert)
+	}
+
+	return raised
+}
+
+// Active alerts of a branch, longest stay first. Empty process means every process
+func listAlerts(branchCode, processCode string) []Alert {
+	alerts.Lock()
+	defer alerts.Unlock()
+
+	list := []Alert{}
+	for _, alert := range alerts.byKey {
+		if alert.Branch == branchCode && (processCode == "" || alert.Process == processCode) {
+			list = append(list, *alert)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Minutes > list[j].Minutes || (list[i].Minutes == list[j].Minutes && list[i].seq() < list[j].seq())
+	})
+	return list
+}
+
+func acknowledgeAlert(branchCode, id string) bool {
+	alerts.Lock()
+	defer alerts.Unlock()
+
+	for _, alert := range alerts.byKey {
+		if alert.ID == id && alert.Branch == branchCode {
+			if !alert.Acknowledged {
+				alert.Acknowledged = true
+				alert.AcknowledgedAt = time.Now()
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func InternalAlertAcknowledgeHandler(w http.ResponseWriter, r *http.Request) {
+	branchCode, ok := requireInternalSession(w, r, "alert")
+	if !ok {
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if !acknowledgeAlert(branchCode, id) {
+		httpError(w, r, "alert tidak ditemukan.", http.StatusNotFound)
+		return
+	}
+	requestLogger(r, InfoLogger).Printf("kmn-internal: alert %v acknowledged by %v", id, branchCode)
+
+	// Send response
+	response := map[string]bool{
+		"success": true,
+	}
+	b, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		requestLogger(r, ErrorLogger).Printf("kmn-internal: fail to marshal response. %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEvaluateAlerts(t *testing.T) {
+	rooms := AppConfig.Rooms["opr"]
+	thresholds := make([]int, len(rooms))
+	for i := range rooms {
+		thresholds[i] = rooms[i].AlertAfter
+		rooms[i].AlertAfter = 0
+	}
+	t.Cleanup(func() {
+		for i := range rooms {
+			rooms[i].AlertAfter = thresholds[i]
+		}
+	})
+	AppConfig.Rooms["opr"][2].AlertAfter = 60 // Ruang Pemulihan
+
+	now := time.Date(2021, 8, 24, 10, 0, 0, 0, time.UTC)
+	ltime := time.Date(0, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	patients := map[string][]PatientLog{
+		"A001": {{Group: "PREPOST", Time: ltime.Add(-time.Minute * 90), Status: "I"}},
+		"A002": {{Group: "PREPOST", Time: ltime.Add(-time.Minute * 30), Status: "I"}},
+		// no threshold for OT
+		"A003": {{Group: "OT", Time: ltime.Add(-time.Minute * 300), Status: "I"}},
+	}
+
+	raised := EvaluateAlerts("kbj", patients, now)
+	if len(raised) != 1 || raised[0].PatientID != "A001" || raised[0].Minutes != 90 {
+		t.Fatalf("wrong raised alerts: %+v", raised)
+	}
+
+	// Same condition doesn't raise alert twice
+	if raised := EvaluateAlerts("kbj", patients, now.Add(time.Minute)); len(raised) != 0 {
+		t.Errorf("alert raised again: %+v", raised)
+	}
+
+	if !acknowledgeAlert("kbj", raised[0].ID) {
+		t.Errorf("fail to acknowledge alert %v", raised[0].ID)
+	}
+	if acknowledgeAlert("kmy", raised[0].ID) {
+		t.Errorf("alert of other branch must not be acknowledged")
+	}
+
+	// Patient left the room: alert is resolved
+	patients["A001"] = append(patients["A001"], PatientLog{Group: "PREPOST", Time: ltime, Status: "O"})
+	EvaluateAlerts("kbj", patients, now)
+	if list := listAlerts("kbj", ""); len(list) != 0 {
+		t.Errorf("alert not resolved: %+v", list)
+	}
+}
+
+func TestAlertSubject(t *testing.T) {
+	type Test struct {
+		name    string
+		alert   Alert
+		want    string
+		encoded bool
+	}
+
+	tests := []Test{
+		{name: "plain", alert: Alert{PatientID: "A001", Room: "Ruang Pemulihan"}, want: "[KMN Antrian] Pasien A001 melebihi batas waktu di Ruang Pemulihan"},
+		{name: "header injection", alert: Alert{PatientID: "A001\r\nBcc: luar@example.com", Room: "Ruang\nPemulihan"}, encoded: true},
+	}
+
+	for _, tt := range tests {
+		get := tt.alert.Subject()
+		if strings.ContainsAny(get, "\r\n") {
+			t.Errorf("case %v: line break in subject: %q", tt.name, get)
+		}
+		if tt.encoded && !strings.HasPrefix(get, "=?UTF-8?q?") {
+			t.Errorf("case %v: subject not encoded: %q", tt.name, get)
+		}
+		if tt.want != "" && get != tt.want {
+			t.Errorf("case %v: wrong subject: get %q want %q", tt.name, get, tt.want)
+		}
+	}
+}
+
+func TestListAlertsOrder(t *testing.T) {
+	alerts.Lock()
+	for _, id := range []string{"10", "9", "11"} {
+		alerts.byKey["order-test|"+id] = &Alert{ID: id, Branch: "zzz", Minutes: 30}
+	}
+	alerts.byKey["order-test|2"] = &Alert{ID: "2", Branch: "zzz", Minutes: 45}
+	alerts.Unlock()
+	t.Cleanup(func() {
+		alerts.Lock()
+		for key := range alerts.byKey {
+			if strings.HasPrefix(key, "order-test|") {
+				delete(alerts.byKey, key)
+			}
+		}
+		alerts.Unlock()
+	})
+
+	var ids []string
+	for _, alert := range listAlerts("zzz", "") {
+		ids = append(ids, alert.ID)
+	}
+	if want := []string{"2", "9", "10", "11"}; strings.Join(ids, ",") != strings.Join(want, ",") {
+		t.Errorf("wrong order: get %v want %v", ids, want)
+	}
+}
+
+// Minimal SMTP relay. Hung relay accepts connection but never greets
+func fakeSMTPServer(t *testing.T, hung bool) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if hung {
+			time.Sleep(time.Second)
+			return
+		}
+
+		reader := bufio.NewReader(conn)
+		fmt.Fprint(conn, "220 relay\r\n")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				fmt.Fprint(conn, "250 OK\r\n")
+			case "DATA":
+				fmt.Fprint(conn, "354 go ahead\r\n")
+				var data strings.Builder
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				fmt.Fprint(conn, "250 OK\r\n")
+			case "QUIT":
+				fmt.Fprint(conn, "221 bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "502 unknown\r\n")
+			}
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestEmailAlertChannel(t *testing.T) {
+	alert := Alert{ID: "1", Branch: "kbj", Process: "opr", PatientID: "A001", Room: "Ruang Pemulihan"}
+
+	addr, received := fakeSMTPServer(t, false)
+	channel := emailAlertChannel{addr: addr, from: "antrian@example.com", to: []string{"perawat@example.com"}, timeout: time.Second}
+	if err := channel.Send(alert); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-received; !strings.Contains(msg, "Subject: "+alert.Subject()+"\r\n") {
+		t.Errorf("wrong message: %q", msg)
+	}
+
+	// Hanging relay must not block alert evaluation
+	addr, _ = fakeSMTPServer(t, true)
+	channel = emailAlertChannel{addr: addr, from: "antrian@example.com", to: []string{"perawat@example.com"}, timeout: 100 * time.Millisecond}
+	start := time.Now()
+	if err := channel.Send(alert); err == nil {
+		t.Errorf("send to hanging relay succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("send to hanging relay took %v", elapsed)
+	}
+}
@@ -1,23 +1,34 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"html"
+	"html/template"
+	"io/fs"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"reflect"
 	"regexp"
 	"sort"
 	"strings"
-	"text/template"
+	"sync"
+	"syscall"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"github.com/microcosm-cc/bluemonday"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -26,6 +37,13 @@ type ProcessData struct {
 	Code string
 }
 
+// Branch choice on home page, with processes it offers (space separated codes)
+type BranchOption struct {
+	Name      string
+	Code      string
+	Processes string
+}
+
 // if new process were to be added, must modify below function and also config file
 var (
 	ProcessLibMap = map[string]string{
@@ -68,11 +86,13 @@ var (
 	TemplateError            *template.Template
 	TemplateLogin            *template.Template
 	TemplateEditNotification *template.Template
+	TemplateBoard            *template.Template
+	TemplateDashboard        *template.Template
+	TemplateReport           *template.Template
 
-	DB *sql.DB
-
-	notificationViper  *viper.Viper
-	notificationPolicy *bluemonday.Policy
+	notificationViper *viper.Viper
+	// Notification is plain text, every markup is stripped
+	notificationPolicy = bluemonday.StrictPolicy()
 )
 
 type RoomDisplay struct {
@@ -91,6 +111,7 @@ var fns = template.FuncMap{
 	"last": func(x int, a interface{}) bool {
 		return x == reflect.ValueOf(a).Len()-1
 	},
+	"asset": assetURL,
 }
 
 func (nfs neuteredFileSystem) Open(path string) (http.File, error) {
@@ -115,63 +136,195 @@ func (nfs neuteredFileSystem) Open(path string) (http.File, error) {
 }
 
 func Initialize() {
-	var err error
-
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s)/%s",
-		AppConfig.DatabaseUser,
-		AppConfig.DatabasePswd,
-		AppConfig.DatabaseAddr,
-		AppConfig.DatabaseName)
-	DB, err = sql.Open("mysql", connectionString)
-	if err != nil {
-		ErrorLogger.Fatalf("fail to open sql connection. %v", err)
+	if err := InitTracing(); err != nil {
+		ErrorLogger.Fatalf("fail to initialize tracing. %v", err)
 	}
 
+	// Open HIS database of every branch
+	OpenDatabases()
+	StartDatabaseHealthCheck(AppConfig.DatabaseCheckInterval)
+
 	// Initialize routes
 	Router = mux.NewRouter()
+	Router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	Router.HandleFunc("/healthz", HealthzHandler).Methods("GET")
+	Router.HandleFunc("/readyz", ReadyzHandler).Methods("GET")
+	Router.HandleFunc("/version", VersionHandler).Methods("GET")
+	Router.HandleFunc(cspReportPath, CSPReportHandler).Methods("POST")
 	Router.HandleFunc("/", HomeHandler).Methods("GET")
 	Router.HandleFunc("/search", DisplayQueueHandler).Methods("GET")
+	Router.HandleFunc("/board/{branch}/{process}", BoardHandler).Methods("GET")
+	Router.HandleFunc("/board/{branch}/{process}/events", BoardEventsHandler).Methods("GET")
 	Router.HandleFunc("/kmn-internal", InternalLoginHandler).Methods("GET", "POST")
 	Router.HandleFunc("/kmn-internal/notification", InternalNotificationSettingGetHandler).Methods("GET")
 	Router.HandleFunc("/kmn-internal/notification", InternalNotificationSettingPostHandler).Methods("POST")
+	Router.HandleFunc("/kmn-internal/dashboard", InternalDashboardHandler).Methods("GET")
+	Router.HandleFunc("/kmn-internal/dashboard/events", InternalDashboardEventsHandler).Methods("GET")
+	Router.HandleFunc("/kmn-internal/alert/{id:[0-9]+}/ack", InternalAlertAcknowledgeHandler).Methods("POST")
+	Router.HandleFunc("/kmn-internal/report", InternalReportGetHandler).Methods("GET")
+	Router.HandleFunc("/kmn-internal/report", InternalReportPostHandler).Methods("POST")
+	Router.HandleFunc("/kmn-internal/report/{id}/{format:csv|xlsx}", InternalReportExportHandler).Methods("GET")
+	Router.HandleFunc("/kmn-internal/cache/purge", InternalCachePurgeHandler).Methods("POST")
 	Router.HandleFunc("/kmn-internal/logout", InternalLogoutHandler).Methods("POST")
 
-	Router.NotFoundHandler = http.HandlerFunc(NotFoundHandler)
+	Router.NotFoundHandler = accessLogMiddleware(tracingMiddleware(metricsMiddleware(securityHeadersMiddleware(http.HandlerFunc(NotFoundHandler)))))
+	Router.Use(accessLogMiddleware, tracingMiddleware, metricsMiddleware, securityHeadersMiddleware)
+
+	files, err := appFiles()
+	if err != nil {
+		ErrorLogger.Fatalf("fail to open asset directory. %v", err)
+	}
 
-	fileserver := http.FileServer(neuteredFileSystem{http.Dir("static")})
-	Router.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fileserver))
+	// Static files are fingerprinted, so pages never need CDN or stale cached file
+	staticFiles, _ := fs.Sub(files, "static")
+	if err := loadAssets(staticFiles); err != nil {
+		ErrorLogger.Fatalf("fail to load static files. %v", err)
+	}
+	Router.PathPrefix("/static/").Handler(staticHandler(staticFiles))
 
 	// Template caching: parse templates here instead in request to avoid delay
-	TemplateHome = template.Must(template.ParseFiles("template/index.html", "template/_header.html"))
-	TemplateDisplay = template.Must(template.New("queue.html").Funcs(fns).ParseFiles("template/queue.html", "template/_header.html", "template/_footer.html"))
-	TemplateError = template.Must(template.ParseFiles("template/error.html", "template/_header.html"))
-
-	TemplateLogin = template.Must(template.ParseFiles("template/login.html"))
-	TemplateEditNotification = template.Must(template.ParseFiles("template/editnotification.html"))
+	if err := parseTemplates(files); err != nil {
+		ErrorLogger.Fatalf("fail to parse templates. %v", err)
+	}
 
 	// Initialize notification database
-	notificationViper = viper.New()
-	notificationViper.SetConfigFile(notificationConfig)
-	notificationPolicy = bluemonday.UGCPolicy()
+	openNotificationStore()
+
+	loggedUserSession = newSessionStore()
+
+	// Drop expired queue lookups
+	StartQueueCacheCleanup()
+
+	// Serve lookups from branch snapshot instead of per-request query
+	if AppConfig.SnapshotMode {
+		StartSnapshotPoller()
+	}
 
-	// Set global default value of cookie expiry duration
-	loggedUserSession = sessions.NewCookieStore(AppConfig.PrimaryKey.Auth, AppConfig.PrimaryKey.Encrypt)
-	loggedUserSession.MaxAge(60 * 30) // 30 minute
+	// Room duration statistics for completion estimate
+	StartDurationStatsJob()
+
+	// Dwell-time alerts for patients staying too long in a room
+	StartAlertEvaluator()
+}
+
+// html/template escapes every value by its context (element, attribute, script), so config and notification text can't inject markup
+func parseTemplates(files fs.FS) error {
+	var err error
+	parse := func(name string, patterns ...string) *template.Template {
+		if err != nil {
+			return nil
+		}
+		var t *template.Template
+		t, err = template.New(name).Funcs(fns).ParseFS(files, patterns...)
+		return t
+	}
+
+	TemplateHome = parse("index.html", "template/index.html", "template/_header.html")
+	TemplateDisplay = parse("queue.html", "template/queue.html", "template/_header.html", "template/_footer.html")
+	TemplateError = parse("error.html", "template/error.html", "template/_header.html")
+	TemplateBoard = parse("board.html", "template/board.html", "template/_footer.html")
+
+	TemplateLogin = parse("login.html", "template/login.html")
+	TemplateEditNotification = parse("editnotification.html", "template/editnotification.html")
+	TemplateDashboard = parse("dashboard.html", "template/dashboard.html")
+	TemplateReport = parse("report.html", "template/report.html")
+	return err
 }
 
-func Run(addr string) {
+// Serve until SIGTERM/SIGINT (or handover signal), then drain in-flight requests
+func Run(addr string) error {
 	server := &http.Server{
 		Handler: Router,
 		Addr:    ":" + addr,
 		// Good practice to set timeouts to avoid Slowloris attacks.
+		// Event streams extend their own write deadline, see StreamFeed
 		WriteTimeout: 10 * time.Second,
 		ReadTimeout:  10 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ErrorLog:     ErrorLogger.StdLogger(),
+		ConnContext:  saveConn,
+	}
+
+	// Certificate is read through reloader, so renewed file is used without restart
+	if AppConfig.TLSEnabled() {
+		reloader, err := newCertReloader(AppConfig.TLSCertFile, AppConfig.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("fail to load TLS certificate. %v", err)
+		}
+		go reloader.watch(AppConfig.TLSReloadInterval, shutdownStarted)
+		server.TLSConfig = tlsConfig(reloader)
+		// HTTP/1.1 only. HTTP/2 applies WriteTimeout per stream, which event streams can't extend through the connection
+		server.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		InfoLogger.Printf("tls enabled with certificate %v", AppConfig.TLSCertFile)
+
+		if AppConfig.HTTPRedirectPort != "" {
+			redirect := serveRedirect(AppConfig.HTTPRedirectPort)
+			defer redirect.Close()
+		}
+	}
+
+	// Socket passed by systemd or by previous process takes precedence over port in config
+	listener, err := inheritedListener()
+	if err != nil {
+		return fmt.Errorf("fail to use inherited socket. %v", err)
+	}
+	if listener != nil {
+		InfoLogger.Printf("app launched on inherited socket %v\n", listener.Addr())
+	} else {
+		listener, err = net.Listen("tcp", server.Addr)
+		if err != nil {
+			return err
+		}
+		InfoLogger.Printf("app launched at localhost:%v\n", server.Addr)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			serveErr <- server.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- server.Serve(listener)
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	if handoverSignal != nil {
+		signal.Notify(signals, handoverSignal)
+	}
+
+	for {
+		select {
+		case err := <-serveErr:
+			return err
+		case sig := <-signals:
+			if sig == handoverSignal {
+				if err := handoverListener(listener); err != nil {
+					ErrorLogger.Printf("fail to start new process for handover, keep serving. %v", err)
+					continue
+				}
+				InfoLogger.Printf("new process started on the same socket")
+			}
+			InfoLogger.Printf("%v received, shutting down", sig)
+			return shutdown(server)
+		}
 	}
-	InfoLogger.Printf("app launched at localhost:%v\n", server.Addr)
-	if err := server.ListenAndServe(); err != nil {
-		panic(err.Error())
+}
+
+func shutdown(server *http.Server) error {
+	// Fail readiness first, and give load balancer time to notice before connections are refused
+	beginShutdown()
+	time.Sleep(AppConfig.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), AppConfig.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+		return fmt.Errorf("requests not drained within %v, connections closed. %v", AppConfig.ShutdownTimeout, err)
 	}
+	InfoLogger.Printf("in-flight requests drained")
+	return nil
 }
 
 func SanitizeID(id string) (string, error) {
@@ -213,86 +366,135 @@ func GetNotification(branchCode string, queueCode string) (string, string) {
 }
 
 func HomeHandler(w http.ResponseWriter, r *http.Request) {
-	var branchCopy []BranchData
+	var branchCopy []BranchOption
 	for _, branch := range AppConfig.Branches {
-		branchCopy = append(branchCopy, BranchData{
-			Name: branch.Name,
-			Code: branch.Code,
+		var processes []string
+		for _, process := range AppConfig.BranchProcesses(branch.Code) {
+			processes = append(processes, process.Code)
+		}
+		branchCopy = append(branchCopy, BranchOption{
+			Name:      branch.Name,
+			Code:      branch.Code,
+			Processes: strings.Join(processes, " "),
 		})
 	}
 
 	payload := map[string]interface{}{
 		"Branches":  branchCopy,
 		"Processes": ProcessLibArr,
+		"Nonce":     CSPNonce(r),
 	}
 	if err := TemplateHome.Execute(w, payload); err != nil {
-		ErrorLogger.Printf("fail to execute template for / endpoint. %v\n", err)
-		http.Error(w, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for / endpoint. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 	}
 }
 
-func DisplayQueueHandler(w http.ResponseWriter, r *http.Request) {
+// Operation rooms are in fixed order, while poli patients can visit rooms in any order
+func ConstructRoomList(logs []PatientLog, branch, process string) []RoomDisplay {
+	switch process {
+	case "opr":
+		return ConstructRoomListBasedOnOrder(logs, branch, process)
+	case "pol":
+		return ConstructRoomListBasedOnTime(logs, branch, process)
+	}
+	return nil
+}
+
+func validateSearchRequest(w http.ResponseWriter, r *http.Request) (string, string, string, bool) {
 	if err := r.ParseForm(); err != nil {
-		ErrorLogger.Printf("fail to parse input from / endpoint. %v\n", err)
-		http.Error(w, "input gagal diproses. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
-		return
+		requestLogger(r, ErrorLogger).Printf("fail to parse input from / endpoint. %v\n", err)
+		httpError(w, r, "input gagal diproses. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+		return "", "", "", false
 	}
 
 	// Validate and sanitize branch
 	branch := r.FormValue("branch")
-	// fmt.Println(branch)
 	if valid := AppConfig.validateBranch(branch); !valid {
-		ErrorLogger.Printf("invalid branch selection. got: %v", branch)
-		http.Error(w, "input cabang tidak valid. silahkan coba lagi.", http.StatusBadRequest)
+		requestLogger(r, WarnLogger).Printf("invalid branch selection. got: %v", branch)
+		httpError(w, r, "input cabang tidak valid. silahkan coba lagi.", http.StatusBadRequest)
 		// [TODO] redirect to index/search
-		return
+		return "", "", "", false
 	}
-	branchName, branchID := AppConfig.getBranchInfo(branch)
 
 	// Validate and sanitize process
 	process := r.FormValue("process")
-	// fmt.Println(process)
 	if valid := validateProcess(process); !valid {
-		ErrorLogger.Printf("invalid process selection. got: %v", process)
-		http.Error(w, "input proses tidak valid. silahkan coba lagi.", http.StatusBadRequest)
+		requestLogger(r, WarnLogger).Printf("invalid process selection. got: %v", process)
+		httpError(w, r, "input proses tidak valid. silahkan coba lagi.", http.StatusBadRequest)
 		// [TODO] redirect to index/search
-		return
+		return "", "", "", false
+	}
+	if !AppConfig.SupportsProcess(branch, process) {
+		requestLogger(r, WarnLogger).Printf("process %v isn't offered at branch %v", process, branch)
+		httpError(w, r, "proses tidak tersedia di cabang ini. silahkan pilih proses lain.", http.StatusBadRequest)
+		return "", "", "", false
 	}
 
 	// Validate and sanitize queue number
 	fullID := r.FormValue("qinput1") + r.FormValue("qinput2") + r.FormValue("qinput3") + r.FormValue("qinput4")
 	fullID, _ = SanitizeID(fullID)
 	if valid := validateID(fullID); !valid {
-		ErrorLogger.Printf("invalid queue number. got: %v", fullID)
-		http.Error(w, "input antrian tidak valid. silahkan coba lagi.", http.StatusBadRequest)
+		requestLogger(r, WarnLogger).With(Fields{"patient": fullID}).Printf("invalid queue number")
+		httpError(w, r, "input antrian tidak valid. silahkan coba lagi.", http.StatusBadRequest)
 		// [TODO] redirect to index/search
+		return "", "", "", false
+	}
+
+	return branch, process, fullID, true
+}
+
+func DisplayQueueHandler(w http.ResponseWriter, r *http.Request) {
+	_, span := tracer.Start(r.Context(), "search.validate")
+	branch, process, fullID, ok := validateSearchRequest(w, r)
+	span.End()
+	if !ok {
 		return
 	}
+	branchName, branchID := AppConfig.getBranchInfo(branch)
+	trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("branch", branch), attribute.String("process", process))
 
-	logs, err := GetQueueLogs(DB, branchID, fullID)
-	if err != nil {
-		switch err {
-		case sql.ErrNoRows:
-			InfoLogger.Printf("no room returned by sql query for %v in %v(%v)", fullID, branchID, branchName)
-			NoDataTemplateDisplay(w, r, fullID, process)
-		default:
-			ErrorLogger.Printf("sql query failed. %v", err)
-			http.Error(w, "input gagal diproses. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
-		}
+	ctx, span := tracer.Start(r.Context(), "search.lookup", branchProcessAttributes(branch, process))
+	logs, takenAt, err := LookupQueueLogs(ctx, branch, branchID, fullID)
+	if err == sql.ErrNoRows {
+		span.End()
+	} else {
+		endSpan(span, err)
+	}
+
+	degraded := false
+	switch err {
+	case nil:
+	case sql.ErrNoRows:
+		requestLogger(r, InfoLogger).With(Fields{"patient": fullID, "branch": branch, "process": process}).Printf("no room returned by sql query for %v(%v)", branchID, branchName)
+		noDataResults.WithLabelValues(branch, process).Inc()
+		NoDataTemplateDisplay(w, r, fullID, process)
 		return
+	default:
+		// Database unavailable (or breaker open): serve last known room list with warning
+		logs, takenAt, ok = LastKnownQueueLogs(branch, fullID)
+		if !ok {
+			requestLogger(r, ErrorLogger).Printf("sql query failed for %v(%v). %v", branchID, branchName, err)
+			UnavailableTemplateDisplay(w, r)
+			return
+		}
+		requestLogger(r, ErrorLogger).Printf("sql query failed for %v(%v), serving last known data of %v. %v", branchID, branchName, takenAt.Format("15:04:05"), err)
+		degraded = true
 	}
 
 	// Arrange logs to room
-	var roomDisplay []RoomDisplay = make([]RoomDisplay, 0)
-	switch process {
-	case "opr":
-		roomDisplay = ConstructRoomListBasedOnOrder(logs, process)
-	case "pol":
-		roomDisplay = ConstructRoomListBasedOnTime(logs, process)
+	_, span = tracer.Start(r.Context(), "search.construct-rooms", branchProcessAttributes(branch, process))
+	roomDisplay := ConstructRoomList(logs, branch, process)
+	var estimate CompletionEstimate
+	if process == "opr" {
+		estimate, _ = EstimateCompletion(logs, branch, getDurationStats(branch), time.Now())
 	}
+	span.SetAttributes(attribute.Int("rooms", len(roomDisplay)))
+	span.End()
 
 	// If logs were not empty, but they are all OPR sequence, then result array would be nil.
 	if len(roomDisplay) == 0 {
+		noDataResults.WithLabelValues(branch, process).Inc()
 		NoDataTemplateDisplay(w, r, fullID, process)
 		return
 	}
@@ -304,15 +506,22 @@ func DisplayQueueHandler(w http.ResponseWriter, r *http.Request) {
 		"Branch":             branchName,
 		"Id":                 fullID,
 		"Rooms":              roomDisplay,
-		"LastUpdated":        time.Now().Format("2006-01-02 15:04:05"),
+		"LastUpdated":        takenAt.Format("2006-01-02 15:04:05"),
+		"Stale":              !degraded && isStale(takenAt, time.Now()),
+		"Degraded":           degraded,
+		"DegradedAt":         takenAt.Format("15:04"),
+		"Estimate":           estimate,
 		"BranchNotification": branchNotification,
 		"RoomNotification":   roomNotification,
 	}
 
 	// Render output
-	if err := TemplateDisplay.Execute(w, payload); err != nil {
-		ErrorLogger.Printf("fail to execute template for display. %v\n", err)
-		http.Error(w, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+	_, span = tracer.Start(r.Context(), "search.render", branchProcessAttributes(branch, process))
+	err = TemplateDisplay.Execute(w, payload)
+	endSpan(span, err)
+	if err != nil {
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for display. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 	}
 }
 
@@ -321,9 +530,31 @@ func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 
 	message := fmt.Sprintf("Halaman tidak ditemukan")
 
-	if err := TemplateError.Execute(w, message); err != nil {
-		ErrorLogger.Printf("fail to execute template for error. %v\n", err)
-		http.Error(w, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+	payload := map[string]interface{}{
+		"Message":   message,
+		"RequestID": RequestID(r),
+	}
+
+	if err := TemplateError.Execute(w, payload); err != nil {
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for error. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+	}
+}
+
+// Database can't be reached and there is no earlier data of the patient
+func UnavailableTemplateDisplay(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusServiceUnavailable)
+
+	message := "Data antrian sedang tidak dapat diambil. Silahkan coba beberapa saat lagi"
+
+	payload := map[string]interface{}{
+		"Message":   message,
+		"RequestID": RequestID(r),
+	}
+
+	if err := TemplateError.Execute(w, payload); err != nil {
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for error. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 	}
 }
 
@@ -334,14 +565,38 @@ func NoDataTemplateDisplay(w http.ResponseWriter, r *http.Request, id, process s
 
 	message := fmt.Sprintf("Data pasien %s untuk %s tidak tersedia", id, processName)
 
-	if err := TemplateError.Execute(w, message); err != nil {
-		ErrorLogger.Printf("fail to execute template for error. %v\n", err)
-		http.Error(w, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+	payload := map[string]interface{}{
+		"Message":   message,
+		"RequestID": RequestID(r),
+	}
+
+	if err := TemplateError.Execute(w, payload); err != nil {
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for error. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+	}
+}
+
+// Group codes without room in config, so a new or mistyped HIS code is noticed. Logged once per branch
+var unknownGroups sync.Map
+
+// Logs of a patient include rooms of every process, so only groups unknown to all processes are reported
+func warnUnknownGroup(branchCode, group string) {
+	if group == "" {
+		return
+	}
+	for _, process := range ProcessLibArr {
+		if _, exist := AppConfig.BranchRoomSet(branchCode, process.Code).Room(group); exist {
+			return
+		}
+	}
+	if _, warned := unknownGroups.LoadOrStore(branchCode+"/"+group, true); !warned {
+		WarnLogger.With(Fields{"branch": branchCode, "group": group}).Printf("group code of queue log matches no room in config")
 	}
 }
 
-func ConstructRoomListBasedOnTime(logs []PatientLog, processCode string) []RoomDisplay {
+func ConstructRoomListBasedOnTime(logs []PatientLog, branchCode, processCode string) []RoomDisplay {
 	defaultTimeTxt := "-"
+	rooms := AppConfig.BranchRoomSet(branchCode, processCode)
 
 	var roomDisplays []RoomDisplay
 
@@ -355,7 +610,7 @@ func ConstructRoomListBasedOnTime(logs []PatientLog, processCode string) []RoomD
 		// Standardize key: lowercase
 		log.Group = strings.ToLower(log.Group)
 
-		if room, valid := AppConfig.RoomMap[processCode][log.Group]; valid {
+		if room, valid := rooms.Room(log.Group); valid {
 			// First entry - immediately add card
 			if len(roomDisplays) == 0 {
 				var rd = RoomDisplay{
@@ -406,14 +661,15 @@ func ConstructRoomListBasedOnTime(logs []PatientLog, processCode string) []RoomD
 				}
 
 				// Display OUT log occurence data:
-				// Special condition for "PP" room: always update OUT time, else first occurence
-				// Group must be lowercased
-				if log.Status == "O" && log.Group == "pp" {
+				// Special condition for "PP" room (any of its codes): always update OUT time, else first occurence
+				if log.Status == "O" && strings.EqualFold(room.GroupCode, "pp") {
 					lastRoom.TimeOut = log.Time.Format("15:04:05")
 				} else if log.Status == "O" && lastRoom.TimeOut == defaultTimeTxt {
 					lastRoom.TimeOut = log.Time.Format("15:04:05")
 				}
 			}
+		} else {
+			warnUnknownGroup(branchCode, log.Group)
 		}
 	}
 
@@ -433,15 +689,17 @@ func ConstructRoomListBasedOnTime(logs []PatientLog, processCode string) []RoomD
 	return roomDisplays
 }
 
-func ConstructRoomListBasedOnOrder(logs []PatientLog, processCode string) []RoomDisplay {
+func ConstructRoomListBasedOnOrder(logs []PatientLog, branchCode, processCode string) []RoomDisplay {
 	defaultTimeTxt := "-"
+	rooms := AppConfig.BranchRoomSet(branchCode, processCode)
 
 	// Fixed length according to config
 	var roomDisplays []RoomDisplay = make([]RoomDisplay, 0)
-	for _, room := range AppConfig.Rooms[processCode] {
+	for _, room := range rooms.Rooms {
 		roomDisplays = append(roomDisplays, RoomDisplay{
 			Name:     room.Name,
 			Time:     defaultTimeTxt,
+			TimeOut:  defaultTimeTxt,
 			IsActive: false,
 		})
 	}
@@ -458,9 +716,9 @@ func ConstructRoomListBasedOnOrder(logs []PatientLog, processCode string) []Room
 		// Standardize key: lowercase
 		log.Group = strings.ToLower(log.Group)
 
-		if room, valid := AppConfig.RoomMap[processCode][log.Group]; valid {
+		if room, valid := rooms.Room(log.Group); valid {
 			// Prevent panicking due invalid index
-			if room.Order < 0 && room.Order >= n {
+			if room.Order < 0 || room.Order >= n {
 				continue
 			}
 
@@ -468,11 +726,16 @@ func ConstructRoomListBasedOnOrder(logs []PatientLog, processCode string) []Room
 			if log.Status == "I" && roomDisplays[room.Order].Time == defaultTimeTxt {
 				roomDisplays[room.Order].Time = log.Time.Format("15:04:05")
 			}
+			if log.Status == "O" && roomDisplays[room.Order].TimeOut == defaultTimeTxt {
+				roomDisplays[room.Order].TimeOut = log.Time.Format("15:04:05")
+			}
 
 			// See 'latest' usage below for explanation
 			if room.Order > latest {
 				latest = room.Order
 			}
+		} else {
+			warnUnknownGroup(branchCode, log.Group)
 		}
 	}
 
@@ -496,10 +759,19 @@ func ConstructRoomListBasedOnOrder(logs []PatientLog, processCode string) []Room
 
 //========================================================================//
 // ** Internal Pages Implementation **//
-var (
-	loggedUserSession  *sessions.CookieStore
-	notificationConfig = "./notification.json"
-)
+var loggedUserSession *sessions.CookieStore
+
+// Session cookie of kmn-internal. Over TLS it's only sent on HTTPS and never with cross-site request
+func newSessionStore() *sessions.CookieStore {
+	store := sessions.NewCookieStore(AppConfig.PrimaryKey.Auth, AppConfig.PrimaryKey.Encrypt)
+	store.MaxAge(60 * 30) // 30 minute
+	store.Options.HttpOnly = true
+	if AppConfig.TLSEnabled() {
+		store.Options.Secure = true
+		store.Options.SameSite = http.SameSiteStrictMode
+	}
+	return store
+}
 
 type Notification struct {
 	Code string `json:"code"`
@@ -517,14 +789,14 @@ func InternalLoginHandler(w http.ResponseWriter, r *http.Request) {
 			// Either no session or session exist but can't be decoded. gorilla.sessions create a new one
 			err := session.Save(r, w) // save the session
 			if err != nil {
-				ErrorLogger.Printf("fail to save kmn-internal session. %v\n", err)
-				http.Error(w, "input gagal diproses. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+				requestLogger(r, ErrorLogger).Printf("fail to save kmn-internal session. %v\n", err)
+				httpError(w, r, "input gagal diproses. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 				return
 			} else {
 				// Serve login page
 				if err := TemplateLogin.Execute(w, nil); err != nil {
-					ErrorLogger.Printf("fail to execute template for login. %v\n", err)
-					http.Error(w, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+					requestLogger(r, ErrorLogger).Printf("fail to execute template for login. %v\n", err)
+					httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 					return
 				}
 			}
@@ -536,16 +808,16 @@ func InternalLoginHandler(w http.ResponseWriter, r *http.Request) {
 			} else {
 				// Serve login page
 				if err := TemplateLogin.Execute(w, nil); err != nil {
-					ErrorLogger.Printf("fail to execute template for login. %v\n", err)
-					http.Error(w, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+					requestLogger(r, ErrorLogger).Printf("fail to execute template for login. %v\n", err)
+					httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 					return
 				}
 			}
 		}
 	} else if r.Method == "POST" {
 		if err := r.ParseForm(); err != nil {
-			ErrorLogger.Printf("fail to parse input from / endpoint. %v\n", err)
-			http.Error(w, "input gagal diproses. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+			requestLogger(r, ErrorLogger).Printf("fail to parse input from / endpoint. %v\n", err)
+			httpError(w, r, "input gagal diproses. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 			return
 		}
 
@@ -558,8 +830,9 @@ func InternalLoginHandler(w http.ResponseWriter, r *http.Request) {
 			if branch.Code == username {
 				err := bcrypt.CompareHashAndPassword([]byte(branch.Password), []byte(password))
 				if err != nil { // user found but password doesn't match
-					InfoLogger.Printf("No matching user-password combination. Inputted User: %v. Password: %v", username, password)
-					http.Error(w, "Kombinasi User and password tidak terdaftar.", http.StatusUnauthorized)
+					loginAttempts.WithLabelValues(username, "failure").Inc()
+					requestLogger(r, WarnLogger).With(Fields{"user": username}).Printf("no matching user-password combination")
+					httpError(w, r, "Kombinasi User and password tidak terdaftar.", http.StatusUnauthorized)
 					return
 				}
 
@@ -568,20 +841,23 @@ func InternalLoginHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 		if !auth { // user not found
-			InfoLogger.Printf("No matching user-password combination. Inputted User: %v. Password: %v", username, password)
-			http.Error(w, "Kombinasi User and password tidak terdaftar.", http.StatusUnauthorized)
+			loginAttempts.WithLabelValues(loginBranchLabel(username), "failure").Inc()
+			requestLogger(r, WarnLogger).With(Fields{"user": username}).Printf("no matching user-password combination")
+			httpError(w, r, "Kombinasi User and password tidak terdaftar.", http.StatusUnauthorized)
 			return
 		}
 
 		// Success
+		loginAttempts.WithLabelValues(username, "success").Inc()
+
 		// Store session
 		session, _ := loggedUserSession.New(r, "authenticated-user-session")
 		session.Values["username"] = username
 		session.Values["authenticated"] = true
 		err := session.Save(r, w)
 		if err != nil {
-			ErrorLogger.Printf("fail to save kmn-internal session. %v\n", err)
-			http.Error(w, "Fail to initialize session", http.StatusInternalServerError)
+			requestLogger(r, ErrorLogger).Printf("fail to save kmn-internal session. %v\n", err)
+			httpError(w, r, "Fail to initialize session", http.StatusInternalServerError)
 			return
 		}
 
@@ -609,12 +885,24 @@ func CheckRequestSession(session *sessions.Session) bool {
 	}
 }
 
+// Returns branch code of logged user. Unauthenticated request is rejected with forbidden response
+func requireInternalSession(w http.ResponseWriter, r *http.Request, page string) (string, bool) {
+	session, _ := loggedUserSession.Get(r, "authenticated-user-session")
+	if !CheckRequestSession(session) {
+		requestLogger(r, InfoLogger).Printf("unauthenticated access to kmn-internal %v page method %v. user: %v\n", page, r.Method, session.Values["username"])
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return "", false
+	}
+
+	return fmt.Sprintf("%v", session.Values["username"]), true
+}
+
 func InternalNotificationSettingGetHandler(w http.ResponseWriter, r *http.Request) {
 	// Reject unauthenticated access
 	session, _ := loggedUserSession.Get(r, "authenticated-user-session")
 	if !CheckRequestSession(session) {
-		InfoLogger.Printf("unauthenticated access to kmn-internal page method GET. user: %v\n", session.Values["username"])
-		http.Error(w, "forbidden", http.StatusForbidden)
+		requestLogger(r, InfoLogger).Printf("unauthenticated access to kmn-internal page method GET. user: %v\n", session.Values["username"])
+		httpError(w, r, "forbidden", http.StatusForbidden)
 		return
 	}
 
@@ -635,7 +923,7 @@ func InternalNotificationSettingGetHandler(w http.ResponseWriter, r *http.Reques
 	n := len(notifications)
 	if n > 0 {
 		if notifications[0].Code != "branch" {
-			ErrorLogger.Printf("kmn-internal: branch in notification.json isn't first entry. structure: %v", notifications)
+			requestLogger(r, ErrorLogger).Printf("kmn-internal: branch in notification.json isn't first entry. structure: %v", notifications)
 		} else {
 			branchNotification = notifications[0].Text
 
@@ -650,21 +938,67 @@ func InternalNotificationSettingGetHandler(w http.ResponseWriter, r *http.Reques
 		"BranchNotification": branchNotification,
 		"QueueNotification":  notifications,
 		"ValidQueueCodeList": ValidQueueCodeList,
+		"CacheStats":         queueLookupCache.stats(),
+		"Nonce":              CSPNonce(r),
 	}
 
 	if err := TemplateEditNotification.Execute(w, payload); err != nil {
-		ErrorLogger.Printf("fail to execute template for edit notification. %v\n", err)
-		http.Error(w, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for edit notification. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 	}
 }
 
+// Notification is plain text. Markup is stripped, and entities escaped by sanitizer are decoded back,
+// so text is stored raw and only escaped once when rendered by html/template
 func sanitizeNotificationInput(text string) string {
-	// Strip malicious html markup
 	cleanHTML := notificationPolicy.Sanitize(text)
-	// Escape all html markup
-	noMarkUpHTML := html.EscapeString(cleanHTML)
+	return html.UnescapeString(cleanHTML)
+}
 
-	return noMarkUpHTML
+func openNotificationStore() {
+	notificationViper = viper.New()
+	notificationViper.SetConfigFile(Paths.Notification)
+	migrateNotificationStore()
+}
+
+// Version of notification.json text format. Before version 2, text was stored html-escaped
+const notificationFormat = 2
+
+// Decode text saved by older version once, so it isn't shown with literal entities (e.g. "&amp;")
+func migrateNotificationStore() {
+	if err := notificationViper.ReadInConfig(); err != nil {
+		return // not created yet
+	}
+	if notificationViper.GetInt("format") >= notificationFormat {
+		return
+	}
+
+	for _, branch := range AppConfig.Branches {
+		notifications := []Notification{}
+		notificationViper.UnmarshalKey(branch.Code, &notifications)
+		if len(notifications) == 0 {
+			continue
+		}
+		for i := range notifications {
+			notifications[i].Text = sanitizeNotificationInput(html.UnescapeString(notifications[i].Text))
+		}
+		notificationViper.Set(branch.Code, notifications)
+	}
+
+	notificationViper.Set("format", notificationFormat)
+	if err := notificationViper.WriteConfig(); err != nil {
+		ErrorLogger.Printf("fail to migrate notification.json to format %v. %v", notificationFormat, err)
+		return
+	}
+	InfoLogger.Printf("notification.json migrated to format %v", notificationFormat)
+}
+
+// Notification with sanitized text. False if code is neither "branch" nor a queue code
+func cleanNotification(n Notification) (Notification, bool) {
+	if n.Code != "branch" && !validateNotificationCode(n.Code) {
+		return Notification{}, false
+	}
+	return Notification{Code: n.Code, Text: sanitizeNotificationInput(n.Text)}, true
 }
 
 func validateNotificationCode(code string) bool {
@@ -676,8 +1010,8 @@ func InternalNotificationSettingPostHandler(w http.ResponseWriter, r *http.Reque
 	// Reject unauthenticated access
 	session, _ := loggedUserSession.Get(r, "authenticated-user-session")
 	if !CheckRequestSession(session) {
-		InfoLogger.Printf("unauthenticated access to kmn-internal page method POST. user: %v\n", session.Values["username"])
-		http.Error(w, "forbidden", http.StatusForbidden)
+		requestLogger(r, InfoLogger).Printf("unauthenticated access to kmn-internal page method POST. user: %v\n", session.Values["username"])
+		httpError(w, r, "forbidden", http.StatusForbidden)
 		return
 	}
 	branchCode := fmt.Sprintf("%v", session.Values["username"])
@@ -686,35 +1020,32 @@ func InternalNotificationSettingPostHandler(w http.ResponseWriter, r *http.Reque
 	notifications := []Notification{}
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&notifications); err != nil {
-		ErrorLogger.Printf("kmn-internal: fail to decode edit-notification payload. %v", err)
-		http.Error(w, "edit gagal disimpan. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+		requestLogger(r, ErrorLogger).Printf("kmn-internal: fail to decode edit-notification payload. %v", err)
+		httpError(w, r, "edit gagal disimpan. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
 		return
 	}
 
 	// Construct new array with clean&valid Code and Text
 	notificationsClean := []Notification{}
 	for i := 0; i < len(notifications); i++ {
-		if notifications[i].Code != "branch" && !validateNotificationCode(notifications[i].Code) {
-			ErrorLogger.Printf("kmn-internal: dropping entry because invalid code. code: %v", notifications[i].Code)
+		clean, valid := cleanNotification(notifications[i])
+		if !valid {
+			requestLogger(r, WarnLogger).Printf("kmn-internal: dropping entry because invalid code. code: %v", notifications[i].Code)
 			continue
 		}
-
-		notifications[i].Text = sanitizeNotificationInput(notifications[i].Text)
-		notificationsClean = append(notificationsClean, Notification{
-			Code: notifications[i].Code,
-			Text: sanitizeNotificationInput(notifications[i].Text),
-		})
+		notificationsClean = append(notificationsClean, clean)
 	}
 
 	// Assert array structure where "branch" is first element
 	n := len(notificationsClean)
 	if n > 0 && notificationsClean[0].Code != "branch" {
-		InfoLogger.Printf("kmn-internal: branch isn't first entry. possible custom JSON payload or else. structure: %v", notificationsClean)
+		requestLogger(r, InfoLogger).Printf("kmn-internal: branch isn't first entry. possible custom JSON payload or else. structure: %v", notificationsClean)
 	}
 
 	// Overwrite config file
-	notificationViper.Set(branchCode, notifications)
+	notificationViper.Set(branchCode, notificationsClean)
 	notificationViper.WriteConfig()
+	notificationSaves.WithLabelValues(branchCode).Inc()
 
 	// Send response
 	response := map[string]bool{
@@ -722,7 +1053,7 @@ func InternalNotificationSettingPostHandler(w http.ResponseWriter, r *http.Reque
 	}
 	b, err := json.MarshalIndent(response, "", "  ")
 	if err != nil {
-		fmt.Println("Marshal err")
+		requestLogger(r, ErrorLogger).Printf("kmn-internal: fail to marshal response. %v", err)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(b)
@@ -1,22 +1,12 @@
 package main
 
 import (
-	"log"
-	"os"
 	"testing"
 	"time"
 )
 
 func TestConstructRoomListBasedOnTime(t *testing.T) {
 	process := "pol"
-	// RoomMap must be populated as reference. That needs logger too..
-	file, err := os.OpenFile("logs.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatal("Fail to initialize logger!")
-	}
-	InfoLogger = log.New(file, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(file, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-	AppConfig.readConfig()
 
 	ctime := time.Now()
 
@@ -158,7 +148,7 @@ func TestConstructRoomListBasedOnTime(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		get := ConstructRoomListBasedOnTime(tt.args, process)
+		get := ConstructRoomListBasedOnTime(tt.args, "kmy", process)
 
 		if len(get) != len(tt.want) {
 			t.Fatalf("case %v: different length: get %v, want %v", tt.name, len(get), len(tt.want))
@@ -189,15 +179,6 @@ func TestConstructRoomListBasedOnOrder(t *testing.T) {
 	process := "opr"
 	ctime := time.Now()
 
-	// RoomMap must be populated as reference. That needs logger too..
-	file, err := os.OpenFile("logs.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatal("Fail to initialize logger!")
-	}
-	InfoLogger = log.New(file, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(file, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
-	AppConfig.readConfig()
-
 	type Test struct {
 		name string
 		args []PatientLog
@@ -335,7 +316,7 @@ func TestConstructRoomListBasedOnOrder(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		get := ConstructRoomListBasedOnOrder(tt.args, process)
+		get := ConstructRoomListBasedOnOrder(tt.args, "kmy", process)
 
 		if len(get) != len(tt.want) {
 			t.Fatalf("case %v: different length: get %v, want %v", tt.name, len(get), len(tt.want))
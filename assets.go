@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Fingerprinted file is never changed, so browser can keep it for a year.
+// File requested by plain name (e.g. old cached page) must be revalidated
+const (
+	fingerprintCacheControl = "public, max-age=31536000, immutable"
+	plainCacheControl       = "no-cache"
+)
+
+// Static files by name, e.g. "css/style.css" -> "css/style.1a2b3c4d5e.css"
+var assetManifest = struct {
+	sync.RWMutex
+	fingerprinted map[string]string // name -> fingerprinted name
+	original      map[string]string // fingerprinted name -> name
+}{}
+
+// Name with content hash before extension, so URL changes whenever file changes
+func fingerprintName(name string, content []byte) string {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])[:10]
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// Hash every static file. Called on start, before templates are rendered
+func loadAssets(fsys fs.FS) error {
+	fingerprinted := map[string]string{}
+	original := map[string]string{}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		hashed := fingerprintName(name, content)
+		fingerprinted[name] = hashed
+		original[hashed] = name
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	assetManifest.Lock()
+	assetManifest.fingerprinted = fingerprinted
+	assetManifest.original = original
+	assetManifest.Unlock()
+
+	DebugLogger.Printf("%v static files fingerprinted", len(fingerprinted))
+	return nil
+}
+
+// Template helper: URL of static file, e.g. {{ asset "css/style.css" }}.
+// Unknown file fails rendering, so broken reference shows up in test instead of as missing style
+func assetURL(name string) (string, error) {
+	assetManifest.RLock()
+	defer assetManifest.RUnlock()
+
+	hashed, exist := assetManifest.fingerprinted[strings.TrimPrefix(name, "/")]
+	if !exist {
+		return "", fmt.Errorf("unknown static file %q", name)
+	}
+	return "/static/" + hashed, nil
+}
+
+// Serve static files under /static/. Fingerprinted name is served from its original file with long-lived cache
+func staticHandler(fsys fs.FS) http.Handler {
+	fileserver := http.FileServer(neuteredFileSystem{http.FS(fsys)})
+
+	return http.StripPrefix("/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assetManifest.RLock()
+		name, fingerprinted := assetManifest.original[r.URL.Path]
+		assetManifest.RUnlock()
+
+		if fingerprinted {
+			w.Header().Set("Cache-Control", fingerprintCacheControl)
+			r.URL.Path = name
+		} else {
+			w.Header().Set("Cache-Control", plainCacheControl)
+		}
+		fileserver.ServeHTTP(w, r)
+	}))
+}
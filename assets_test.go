@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStaticAssets(t *testing.T) {
+	files := fstest.MapFS{
+		"css/style.css":   {Data: []byte("body { color: black; }")},
+		"js/board.js":     {Data: []byte("connectBoard();")},
+		"assets/logo.ico": {Data: []byte("ico")},
+	}
+	if err := loadAssets(files); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		staticFiles, _ := fs.Sub(embeddedFiles, "static")
+		loadAssets(staticFiles)
+	}()
+
+	style, err := assetURL("css/style.css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^/static/css/style\.[0-9a-f]{10}\.css$`).MatchString(style) {
+		t.Errorf("wrong fingerprinted URL: %v", style)
+	}
+	if _, err := assetURL("css/missing.css"); err == nil {
+		t.Errorf("unknown file has URL")
+	}
+
+	// Changed content changes URL
+	files["css/style.css"] = &fstest.MapFile{Data: []byte("body { color: red; }")}
+	loadAssets(files)
+	if changed, _ := assetURL("css/style.css"); changed == style {
+		t.Errorf("URL isn't changed with content: %v", changed)
+	}
+	style, _ = assetURL("css/style.css")
+
+	type Test struct {
+		name         string
+		path         string
+		code         int
+		cacheControl string
+		body         string
+	}
+
+	tests := []Test{
+		{name: "fingerprinted", path: style, code: http.StatusOK, cacheControl: fingerprintCacheControl, body: "body { color: red; }"},
+		{name: "plain name", path: "/static/js/board.js", code: http.StatusOK, cacheControl: plainCacheControl, body: "connectBoard();"},
+		{name: "outdated fingerprint", path: "/static/css/style.0000000000.css", code: http.StatusNotFound, cacheControl: plainCacheControl},
+		{name: "directory listing", path: "/static/css/", code: http.StatusNotFound, cacheControl: plainCacheControl},
+	}
+
+	handler := staticHandler(files)
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+		if w.Code != tt.code {
+			t.Errorf("case %v: wrong status code: get %v want %v", tt.name, w.Code, tt.code)
+		}
+		if get := w.Header().Get("Cache-Control"); get != tt.cacheControl {
+			t.Errorf("case %v: wrong Cache-Control: get %v want %v", tt.name, get, tt.cacheControl)
+		}
+		if tt.body != "" {
+			if body, _ := io.ReadAll(w.Body); string(body) != tt.body {
+				t.Errorf("case %v: wrong body: get %q want %q", tt.name, body, tt.body)
+			}
+		}
+	}
+}
+
+// Branch networks are isolated, so no page may load anything from internet
+func TestTemplatesUseLocalAssets(t *testing.T) {
+	external := regexp.MustCompile(`(src|href)="(https?:)?//`)
+	templates, _ := filepath.Glob("template/*.html")
+	for _, path := range templates {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match := external.Find(content); match != nil {
+			t.Errorf("case %v: external resource %v", path, string(match))
+		}
+		if strings.Contains(string(content), `"/static/`) {
+			t.Errorf("case %v: static file isn't referenced with asset helper", path)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Queue numbers shown for one room in waiting-room board
+type BoardRoom struct {
+	Name   string   `json:"name"`
+	Active []string `json:"active"` // currently in room, first entered first
+	Recent []string `json:"recent"` // called into room within BOARD_RECENT_WINDOW and already left, latest first
+}
+
+type BoardPayload struct {
+	Rooms              []BoardRoom `json:"rooms"`
+	BranchNotification string      `json:"branch-notification"`
+	LastUpdated        string      `json:"last-updated"`
+}
+
+// Group patients by room, based on the same room list shown to each patient
+func ConstructBoard(patients map[string][]PatientLog, branchCode, processCode string, now time.Time) []BoardRoom {
+	type entry struct {
+		ID   string
+		Time string
+	}
+
+	var names []string
+	active := make(map[string][]entry)
+	recent := make(map[string][]entry)
+	for _, room := range AppConfig.BranchRoomSet(branchCode, processCode).Rooms {
+		if _, exist := active[room.Name]; exist {
+			continue
+		}
+
+		names = append(names, room.Name)
+		active[room.Name] = []entry{}
+		recent[room.Name] = []entry{}
+	}
+
+	// Log time has no date, so window is not applied shortly after midnight
+	recentSince := ""
+	if window := AppConfig.BoardRecentWindow; window > 0 && now.Add(-window).Day() == now.Day() {
+		recentSince = now.Add(-window).Format("15:04:05")
+	}
+
+	for id, logs := range patients {
+		var roomDisplays []RoomDisplay
+		switch processCode {
+		case "opr":
+			roomDisplays = ConstructRoomListBasedOnOrder(logs, branchCode, processCode)
+		case "pol":
+			roomDisplays = ConstructRoomListBasedOnTime(logs, branchCode, processCode)
+		}
+
+		for _, rd := range roomDisplays {
+			if _, exist := active[rd.Name]; !exist || rd.Time == "-" {
+				continue
+			}
+
+			if rd.IsActive {
+				active[rd.Name] = append(active[rd.Name], entry{id, rd.Time})
+			} else if rd.Time >= recentSince {
+				recent[rd.Name] = append(recent[rd.Name], entry{id, rd.Time})
+			}
+		}
+	}
+
+	// Time is formatted as 15:04:05, so it can be compared as string
+	board := make([]BoardRoom, 0, len(names))
+	for _, name := range names {
+		a, r := active[name], recent[name]
+		sort.Slice(a, func(i, j int) bool {
+			return a[i].Time < a[j].Time || (a[i].Time == a[j].Time && a[i].ID < a[j].ID)
+		})
+		sort.Slice(r, func(i, j int) bool {
+			return r[i].Time > r[j].Time || (r[i].Time == r[j].Time && r[i].ID < r[j].ID)
+		})
+		if len(r) > AppConfig.BoardRecentCount {
+			r = r[:AppConfig.BoardRecentCount]
+		}
+
+		room := BoardRoom{Name: name, Active: []string{}, Recent: []string{}}
+		for _, e := range a {
+			room.Active = append(room.Active, e.ID)
+		}
+		for _, e := range r {
+			room.Recent = append(room.Recent, e.ID)
+		}
+		board = append(board, room)
+	}
+
+	return board
+}
+
+func BuildBoardPayload(branchCode, processCode string) (BoardPayload, error) {
+	patients, takenAt, err := LookupBranchQueueLogs(branchCode)
+	if err != nil {
+		return BoardPayload{}, err
+	}
+
+	branchNotification, _ := GetNotification(branchCode, "")
+
+	return BoardPayload{
+		Rooms:              ConstructBoard(patients, branchCode, processCode, takenAt),
+		BranchNotification: branchNotification,
+		LastUpdated:        takenAt.Format("2006-01-02 15:04:05"),
+	}, nil
+}
+
+//========================================================================//
+// ** Handlers **//
+
+func validateBoardRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	vars := mux.Vars(r)
+
+	branch := vars["branch"]
+	if valid := AppConfig.validateBranch(branch); !valid {
+		requestLogger(r, WarnLogger).Printf("board: invalid branch selection. got: %v", branch)
+		httpError(w, r, "input cabang tidak valid.", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	process := vars["process"]
+	if valid := validateProcess(process); !valid {
+		requestLogger(r, WarnLogger).Printf("board: invalid process selection. got: %v", process)
+		httpError(w, r, "input proses tidak valid.", http.StatusBadRequest)
+		return "", "", false
+	}
+	if !AppConfig.SupportsProcess(branch, process) {
+		requestLogger(r, WarnLogger).Printf("board: process %v isn't offered at branch %v", process, branch)
+		httpError(w, r, "proses tidak tersedia di cabang ini.", http.StatusNotFound)
+		return "", "", false
+	}
+
+	return branch, process, true
+}
+
+func BoardHandler(w http.ResponseWriter, r *http.Request) {
+	branch, process, ok := validateBoardRequest(w, r)
+	if !ok {
+		return
+	}
+	branchName, _ := AppConfig.getBranchInfo(branch)
+
+	// Initial data. If it fails, page is still served and filled by event stream later
+	board, err := BuildBoardPayload(branch, process)
+	if err != nil {
+		requestLogger(r, ErrorLogger).Printf("board: sql query failed for %v/%v. %v", branch, process, err)
+		board = BoardPayload{Rooms: ConstructBoard(nil, branch, process, time.Now())}
+	}
+
+	payload := map[string]interface{}{
+		"Branch":             branchName,
+		"Process":            ProcessLibMap[process],
+		"Rooms":              board.Rooms,
+		"BranchNotification": board.BranchNotification,
+		"LastUpdated":        board.LastUpdated,
+	}
+	if err := TemplateBoard.Execute(w, payload); err != nil {
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for board. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+	}
+}
+
+func BoardEventsHandler(w http.ResponseWriter, r *http.Request) {
+	branch, process, ok := validateBoardRequest(w, r)
+	if !ok {
+		return
+	}
+
+	StreamFeed(w, r, "board/"+branch+"/"+process, func() (interface{}, error) {
+		return BuildBoardPayload(branch, process)
+	})
+}
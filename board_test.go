@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConstructBoard(t *testing.T) {
+	count, window := AppConfig.BoardRecentCount, AppConfig.BoardRecentWindow
+	t.Cleanup(func() { AppConfig.BoardRecentCount, AppConfig.BoardRecentWindow = count, window })
+	AppConfig.BoardRecentCount = 2
+	AppConfig.BoardRecentWindow = 30 * time.Minute
+
+	ctime := time.Date(0, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	patients := map[string][]PatientLog{
+		"A001": {
+			{Group: "REG", Time: ctime, Status: "I"},
+			{Group: "RM", Time: ctime.Add(time.Minute * 1), Status: "I"},
+		},
+		"A002": {
+			{Group: "REG", Time: ctime.Add(time.Minute * 2), Status: "I"},
+		},
+		"A003": {
+			{Group: "REG", Time: ctime.Add(time.Minute * 3), Status: "I"},
+			{Group: "REG", Time: ctime.Add(time.Minute * 4), Status: "O"},
+		},
+		"A004": {
+			{Group: "REG", Time: ctime.Add(time.Minute * 1), Status: "I"},
+			{Group: "RM", Time: ctime.Add(time.Minute * 5), Status: "I"},
+		},
+		// OPR only, not shown in POL board
+		"B001": {
+			{Group: "OT", Time: ctime, Status: "I"},
+		},
+	}
+
+	now := time.Date(2021, 8, 24, 8, 10, 0, 0, time.UTC)
+	board := ConstructBoard(patients, "kmy", "pol", now)
+
+	// Only visible rooms of config are shown
+	if len(board) != 7 {
+		t.Fatalf("different length: get %v, want %v", len(board), 7)
+	}
+
+	want := []BoardRoom{
+		{Name: "Registrasi", Active: []string{"A002"}, Recent: []string{"A003", "A004"}},
+		{Name: "Rekam Medik", Active: []string{"A001", "A004"}, Recent: []string{}},
+		{Name: "Pemeriksaan Awal", Active: []string{}, Recent: []string{}},
+	}
+	for i, w := range want {
+		if !reflect.DeepEqual(board[i], w) {
+			t.Errorf("room %v: get %+v want %+v", w.Name, board[i], w)
+		}
+	}
+
+	// A004 was called into Registrasi more than BOARD_RECENT_WINDOW ago
+	board = ConstructBoard(patients, "kmy", "pol", now.Add(time.Minute*22))
+	if get := board[0].Recent; !reflect.DeepEqual(get, []string{"A003"}) {
+		t.Errorf("recent outside window: get %v want [A003]", get)
+	}
+
+	// Window isn't applied shortly after midnight, as log time has no date
+	board = ConstructBoard(patients, "kmy", "pol", time.Date(2021, 8, 24, 0, 10, 0, 0, time.UTC))
+	if get := board[0].Recent; len(get) != 2 {
+		t.Errorf("recent filtered after midnight: get %v", get)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Returned instead of querying database while breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker is open, database query skipped")
+
+// Stop querying a database after consecutive failures, so requests don't pile up waiting for timeout.
+// After cooldown, one trial query decides whether breaker is closed again
+type circuitBreaker struct {
+	sync.Mutex
+	name      string
+	threshold int
+	cooldown  time.Duration
+
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool // trial query of half-open state is running
+}
+
+func newCircuitBreaker(name string, threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{name: name, threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+func (b *circuitBreaker) allow(now time.Time) error {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return nil
+	case BreakerHalfOpen:
+		if b.trial {
+			return ErrCircuitOpen
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// No data is a successful query
+func (b *circuitBreaker) record(err error, now time.Time) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.trial = false
+	if err == nil || err == sql.ErrNoRows {
+		if b.state != BreakerClosed {
+			InfoLogger.Printf("circuit breaker of database %v is closed", b.name)
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		if b.state == BreakerClosed {
+			ErrorLogger.Printf("circuit breaker of database %v is open after %v failures. %v", b.name, b.failures, err)
+		}
+		b.state = BreakerOpen
+		b.openedAt = now
+	}
+}
+
+func (b *circuitBreaker) State() string {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.state
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2021, 8, 24, 10, 0, 0, 0, time.UTC)
+	down := errors.New("connection refused")
+	breaker := newCircuitBreaker("default", 3, time.Minute)
+
+	type Test struct {
+		name  string
+		at    time.Duration // since now
+		err   error         // result of query, if allowed
+		allow bool
+		state string
+	}
+
+	tests := []Test{
+		{name: "no data is success", err: sql.ErrNoRows, allow: true, state: BreakerClosed},
+		{name: "first failure", err: down, allow: true, state: BreakerClosed},
+		{name: "second failure", err: down, allow: true, state: BreakerClosed},
+		{name: "threshold reached", err: down, allow: true, state: BreakerOpen},
+		{name: "cooldown", at: time.Second * 30, allow: false, state: BreakerOpen},
+		{name: "failed trial", at: time.Minute, err: down, allow: true, state: BreakerOpen},
+		{name: "cooldown restarted", at: time.Minute + time.Second*30, allow: false, state: BreakerOpen},
+		{name: "successful trial", at: time.Minute * 2, allow: true, state: BreakerClosed},
+	}
+
+	for _, tt := range tests {
+		err := breaker.allow(now.Add(tt.at))
+		if allow := err == nil; allow != tt.allow {
+			t.Errorf("case %v: wrong allow: get %v want %v", tt.name, allow, tt.allow)
+		}
+		if err == nil {
+			breaker.record(tt.err, now.Add(tt.at))
+		}
+		if state := breaker.State(); state != tt.state {
+			t.Errorf("case %v: wrong state: get %v want %v", tt.name, state, tt.state)
+		}
+	}
+
+	// Only one trial query runs while half-open
+	breaker = newCircuitBreaker("default", 1, time.Minute)
+	breaker.allow(now)
+	breaker.record(down, now)
+	if err := breaker.allow(now.Add(time.Minute)); err != nil {
+		t.Errorf("trial query not allowed. %v", err)
+	}
+	if err := breaker.allow(now.Add(time.Minute)); err != ErrCircuitOpen {
+		t.Errorf("second query allowed during trial")
+	}
+}
+
+func TestLastKnownQueueLogs(t *testing.T) {
+	takenAt := time.Now()
+	logs := []PatientLog{{Group: "PREOP", Status: "I"}}
+	rememberQueueLogs(queueCacheKey("kbj", "A001", queueDate()), logs, takenAt)
+	rememberQueueLogs(queueCacheKey("kbj", "A001", "2000-01-01"), logs, takenAt)
+
+	known, at, ok := LastKnownQueueLogs("kbj", "A001")
+	if !ok || len(known) != 1 || !at.Equal(takenAt) {
+		t.Errorf("wrong last known logs: %+v %v %v", known, at, ok)
+	}
+	if _, _, ok := LastKnownQueueLogs("kmy", "A001"); ok {
+		t.Errorf("last known logs of other branch returned")
+	}
+
+	forgetOutdatedQueueLogs(queueDate())
+	if n := len(lastKnown.byKey); n != 1 {
+		t.Errorf("outdated logs not forgotten: %v entries", n)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Result of one queue lookup. No-data result is cached too, families usually search before entering first room
+type queueCacheEntry struct {
+	Logs      []PatientLog
+	Err       error
+	FetchedAt time.Time // shown to patient as time of data
+	Expires   time.Time
+}
+
+type QueueCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// Read-through cache of queue lookups keyed by branch, patient and date.
+// Concurrent lookups of the same key are coalesced into one query
+type queueCache struct {
+	sync.Mutex
+	entries map[string]queueCacheEntry
+	group   singleflight.Group
+
+	hits   uint64
+	misses uint64
+}
+
+var queueLookupCache = newQueueCache()
+
+func newQueueCache() *queueCache {
+	return &queueCache{entries: make(map[string]queueCacheEntry)}
+}
+
+func queueCacheKey(branchCode, patientID, date string) string {
+	return strings.Join([]string{branchCode, patientID, date}, "|")
+}
+
+// Cached entry or result of fetch, with the time it was fetched.
+// Only no-data error is cached, other errors (e.g. database down) are retried on next lookup
+func (c *queueCache) lookup(key string, ttl time.Duration, fetch func() ([]PatientLog, error)) ([]PatientLog, time.Time, error) {
+	c.Lock()
+	entry, exist := c.entries[key]
+	c.Unlock()
+
+	if exist && time.Now().Before(entry.Expires) {
+		atomic.AddUint64(&c.hits, 1)
+		return copyLogs(entry.Logs), entry.FetchedAt, entry.Err
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	// Lookups joining a running fetch share its time too
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		fetchedAt := time.Now()
+		logs, err := fetch()
+		entry := queueCacheEntry{Logs: logs, Err: err, FetchedAt: fetchedAt, Expires: time.Now().Add(ttl)}
+		if err == nil || err == sql.ErrNoRows {
+			c.Lock()
+			c.entries[key] = entry
+			c.Unlock()
+		}
+		return entry, err
+	})
+
+	entry, _ = v.(queueCacheEntry)
+	return copyLogs(entry.Logs), entry.FetchedAt, err
+}
+
+// Remove all entries of a branch. Returns number of removed entries
+func (c *queueCache) purge(branchCode string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	n := 0
+	for key := range c.entries {
+		if strings.HasPrefix(key, branchCode+"|") {
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *queueCache) removeExpired(now time.Time) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, entry := range c.entries {
+		if !now.Before(entry.Expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *queueCache) stats() QueueCacheStats {
+	c.Lock()
+	defer c.Unlock()
+
+	return QueueCacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: len(c.entries),
+	}
+}
+
+// Room constructors sort logs in place, so every caller gets its own copy of cached logs
+func copyLogs(logs []PatientLog) []PatientLog {
+	if logs == nil {
+		return nil
+	}
+	return append([]PatientLog(nil), logs...)
+}
+
+// Periodically drop expired entries, so patients searched once don't stay in memory
+func StartQueueCacheCleanup() {
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			queueLookupCache.removeExpired(time.Now())
+			forgetOutdatedQueueLogs(queueDate())
+		}
+	}()
+}
+
+// GetQueueLogs through cache and circuit breaker. Successful result is also kept for degraded mode
+// Concurrent lookups are traced under the span of the first one
+func GetCachedQueueLogs(ctx context.Context, branchCode, branchID, patientID string) ([]PatientLog, time.Time, error) {
+	key := queueCacheKey(branchCode, patientID, queueDate())
+	return queueLookupCache.lookup(key, AppConfig.QueueCacheTTL, func() ([]PatientLog, error) {
+		var logs []PatientLog
+		err := queryBranch(ctx, branchCode, "patient", func(db *sql.DB) error {
+			var err error
+			logs, err = GetQueueLogs(db, branchID, patientID)
+			return err
+		})
+		if err == nil {
+			rememberQueueLogs(key, logs, time.Now())
+		}
+		return logs, err
+	})
+}
+
+func InternalCachePurgeHandler(w http.ResponseWriter, r *http.Request) {
+	branchCode, ok := requireInternalSession(w, r, "cache")
+	if !ok {
+		return
+	}
+
+	n := queueLookupCache.purge(branchCode)
+	requestLogger(r, InfoLogger).Printf("kmn-internal: %v cache entries purged by %v", n, branchCode)
+
+	// Send response
+	response := map[string]interface{}{
+		"success": true,
+		"purged":  n,
+	}
+	b, err := json.MarshalIndent(response, "", "  ")
+	if err != nil {
+		requestLogger(r, ErrorLogger).Printf("kmn-internal: fail to marshal response. %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
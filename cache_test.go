@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueCacheLookup(t *testing.T) {
+	cache := newQueueCache()
+	var fetched int32
+	release := make(chan struct{})
+
+	fetch := func() ([]PatientLog, error) {
+		atomic.AddInt32(&fetched, 1)
+		<-release
+		return []PatientLog{{Group: "PREOP", Status: "I"}}, nil
+	}
+
+	// Concurrent lookups of the same key run one query
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if logs, _, err := cache.lookup("kbj|A001|2021-08-24", time.Minute, fetch); err != nil || len(logs) != 1 {
+				t.Errorf("wrong lookup result: %v %v", logs, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if fetched != 1 {
+		t.Errorf("concurrent lookups not coalesced: %v queries", fetched)
+	}
+
+	// Cached logs can't be modified by caller
+	logs, _, _ := cache.lookup("kbj|A001|2021-08-24", time.Minute, fetch)
+	logs[0].Group = "OT"
+	if logs, _, _ := cache.lookup("kbj|A001|2021-08-24", time.Minute, fetch); logs[0].Group != "PREOP" || fetched != 1 {
+		t.Errorf("cache entry modified or refetched: %v (%v queries)", logs, fetched)
+	}
+
+	// Cached logs keep time of fetch, so staleness is shown correctly
+	_, first, _ := cache.lookup("kbj|A001|2021-08-24", time.Minute, fetch)
+	time.Sleep(10 * time.Millisecond)
+	if _, again, _ := cache.lookup("kbj|A001|2021-08-24", time.Minute, fetch); first.IsZero() || !again.Equal(first) {
+		t.Errorf("cached logs reported as fetched at %v, first lookup %v", again, first)
+	}
+
+	if stats := cache.stats(); stats.Hits != 4 || stats.Misses != 10 || stats.Entries != 1 {
+		t.Errorf("wrong stats: %+v", stats)
+	}
+}
+
+func TestQueueCacheError(t *testing.T) {
+	cache := newQueueCache()
+
+	// No data is cached, other error is not
+	cache.lookup("kbj|A001|2021-08-24", time.Minute, func() ([]PatientLog, error) { return nil, sql.ErrNoRows })
+	cache.lookup("kbj|A002|2021-08-24", time.Minute, func() ([]PatientLog, error) { return nil, errors.New("connection refused") })
+	if stats := cache.stats(); stats.Entries != 1 {
+		t.Errorf("wrong cached entries: get %v want 1", stats.Entries)
+	}
+	if _, _, err := cache.lookup("kbj|A001|2021-08-24", time.Minute, nil); err != sql.ErrNoRows {
+		t.Errorf("cached no-data not returned: %v", err)
+	}
+
+	// Expired entry is removed
+	cache.lookup("kmy|A001|2021-08-24", -time.Second, func() ([]PatientLog, error) { return nil, sql.ErrNoRows })
+	cache.removeExpired(time.Now())
+	if stats := cache.stats(); stats.Entries != 1 {
+		t.Errorf("expired entry not removed: %v entries", stats.Entries)
+	}
+
+	// Purge only affects given branch
+	cache.lookup("kmy|A001|2021-08-24", time.Minute, func() ([]PatientLog, error) { return nil, sql.ErrNoRows })
+	if n := cache.purge("kbj"); n != 1 || cache.stats().Entries != 1 {
+		t.Errorf("wrong purge: %v removed, %v left", n, cache.stats().Entries)
+	}
+}
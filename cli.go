@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const commandUsage = `  serve                                          run the server (default)
+  hash-password [-cost n]                        read password from stdin, print bcrypt hash for branch "password" in config.json
+  validate-config                                load config.env and config.json, report every problem
+  lookup -branch code -process opr|pol ID        print room list of a patient
+  notifications export [-o file]                 print notification.json
+  notifications import file                      replace notifications of branches in file
+
+global flags (-config, -config-json, ...) go before command, e.g. queueinfo -config /etc/queueinfo/config.env lookup ...
+`
+
+// Commands other than serve. Log goes to stderr, so stdout only has the result
+func runCommand(command string, args []string, stdin io.Reader, stdout io.Writer) error {
+	switch command {
+	case "hash-password":
+		return hashPasswordCommand(args, stdin, stdout)
+	case "validate-config":
+		return validateConfigCommand(args, stdout)
+	case "lookup":
+		return lookupCommand(args, stdout)
+	case "notifications":
+		return notificationsCommand(args, stdout)
+	}
+	return fmt.Errorf("unknown command. commands:\n%v", commandUsage)
+}
+
+func loadCommandConfig() {
+	initCommandLogger()
+	AppConfig.readConfig()
+}
+
+func initCommandLogger() {
+	settings := defaultLogSettings()
+	settings.Output = "stderr"
+	settings.Level = LevelWarn
+	if err := InitLogger(settings); err != nil {
+		fmt.Fprintf(os.Stderr, "fail to initialize logger. %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// Global flags given after command end up here, so usage tells where they belong
+func commandFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage of %v (global flags like -config go before %v):\n", name, name)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+//========================================================================//
+// ** hash-password **//
+
+// Password is read from stdin instead of argument, so it isn't kept in shell history
+func hashPasswordCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := commandFlags("hash-password")
+	cost := flags.Int("cost", bcrypt.DefaultCost, "bcrypt cost")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if f, ok := stdin.(*os.File); ok {
+		if info, err := f.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(os.Stderr, "Password: ")
+		}
+	}
+	password, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("empty password")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), *cost)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(hash))
+	return nil
+}
+
+//========================================================================//
+// ** validate-config **//
+
+// Same checks as startup, plus files only opened later. Config isn't exited on, so every problem is listed
+func validateConfigCommand(args []string, stdout io.Writer) error {
+	if err := commandFlags("validate-config").Parse(args); err != nil {
+		return err
+	}
+	initCommandLogger()
+
+	problems := AppConfig.loadConfig()
+	report := func(err error, format string, v ...interface{}) {
+		if err != nil {
+			problems.errorf(format+" %v", append(v, err)...)
+		}
+	}
+
+	report(checkNotificationStore(), "notification file %v:", Paths.Notification)
+	files, err := appFiles()
+	report(err, "asset directory %v:", Paths.AssetDir)
+	if err == nil {
+		report(parseTemplates(files), "templates:")
+	}
+	if AppConfig.TLSEnabled() {
+		_, err := newCertReloader(AppConfig.TLSCertFile, AppConfig.TLSKeyFile)
+		report(err, "tls certificate:")
+	}
+
+	for _, problem := range problems.Errors {
+		fmt.Fprintf(stdout, "error: %v\n", problem)
+	}
+	for _, warning := range problems.Warnings {
+		fmt.Fprintf(stdout, "warning: %v\n", warning)
+	}
+	if len(problems.Errors) > 0 {
+		return fmt.Errorf("%v error(s) and %v warning(s) found", len(problems.Errors), len(problems.Warnings))
+	}
+	if len(problems.Warnings) > 0 {
+		fmt.Fprintf(stdout, "config is valid with %v warning(s)\n", len(problems.Warnings))
+		return nil
+	}
+	fmt.Fprintln(stdout, "config is valid")
+	return nil
+}
+
+//========================================================================//
+// ** lookup **//
+
+// Same query and room list as search page, bypassing snapshot
+func lookupCommand(args []string, stdout io.Writer) error {
+	flags := commandFlags("lookup")
+	branch := flags.String("branch", "", "branch code")
+	process := flags.String("process", "opr", "process code (opr or pol)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("queue number is required, e.g. lookup -branch kmy -process opr A001")
+	}
+	loadCommandConfig()
+
+	if !AppConfig.validateBranch(*branch) {
+		return fmt.Errorf("invalid branch %q", *branch)
+	}
+	if !validateProcess(*process) {
+		return fmt.Errorf("invalid process %q", *process)
+	}
+	if !AppConfig.SupportsProcess(*branch, *process) {
+		return fmt.Errorf("process %v isn't offered at branch %v", *process, *branch)
+	}
+	id, _ := SanitizeID(flags.Arg(0))
+	if !validateID(id) {
+		return fmt.Errorf("invalid queue number %q", flags.Arg(0))
+	}
+
+	OpenDatabases()
+	defer CloseDatabases()
+
+	branchName, branchID := AppConfig.getBranchInfo(*branch)
+	logs, _, err := GetCachedQueueLogs(context.Background(), *branch, branchID, id)
+	if err == sql.ErrNoRows {
+		fmt.Fprintf(stdout, "no data for %v at %v\n", id, branchName)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("query failed for %v(%v). %v", branchID, branchName, err)
+	}
+
+	rooms := ConstructRoomList(logs, *branch, *process)
+	if len(rooms) == 0 {
+		fmt.Fprintf(stdout, "no %v room for %v at %v\n", ProcessLibMap[*process], id, branchName)
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "%v - %v - %v\n", branchName, ProcessLibMap[*process], id)
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ROOM\tIN\tOUT\tACTIVE")
+	for _, room := range rooms {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", room.Name, room.Time, room.TimeOut, room.IsActive)
+	}
+	return w.Flush()
+}
+
+//========================================================================//
+// ** notifications **//
+
+func notificationsCommand(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("use notifications export or notifications import")
+	}
+	switch args[0] {
+	case "export":
+		return exportNotificationsCommand(args[1:], stdout)
+	case "import":
+		return importNotificationsCommand(args[1:], stdout)
+	}
+	return fmt.Errorf("unknown notifications command %q", args[0])
+}
+
+// Notification file as is, including its format version, so it can be imported back
+func exportNotificationsCommand(args []string, stdout io.Writer) error {
+	flags := commandFlags("notifications export")
+	output := flags.String("o", "", "output file (default stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(Paths.Notification)
+	if os.IsNotExist(err) || (err == nil && len(strings.TrimSpace(string(content))) == 0) {
+		content, err = []byte("{}"), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if err := json.Indent(&b, content, "", "  "); err != nil {
+		return fmt.Errorf("%v isn't valid JSON. %v", Paths.Notification, err)
+	}
+	b.WriteString("\n")
+
+	if *output == "" {
+		_, err = stdout.Write(b.Bytes())
+		return err
+	}
+	return os.WriteFile(*output, b.Bytes(), 0644)
+}
+
+// Branches in file replace their notifications, other branches are kept.
+// Nothing is written if any entry is invalid
+func importNotificationsCommand(args []string, stdout io.Writer) error {
+	flags := commandFlags("notifications import")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("file to import is required")
+	}
+	loadCommandConfig()
+
+	content, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return fmt.Errorf("%v isn't valid JSON. %v", flags.Arg(0), err)
+	}
+
+	// Export of older version has escaped text
+	format := 0
+	if raw, exist := entries["format"]; exist {
+		if err := json.Unmarshal(raw, &format); err != nil {
+			return fmt.Errorf("%v has invalid format %s, must be a number", flags.Arg(0), raw)
+		}
+		delete(entries, "format")
+	}
+
+	var problems []string
+	imported := map[string][]Notification{}
+	for branch, raw := range entries {
+		if !AppConfig.validateBranch(branch) {
+			problems = append(problems, fmt.Sprintf("unknown branch %q", branch))
+			continue
+		}
+		var notifications []Notification
+		if err := json.Unmarshal(raw, &notifications); err != nil {
+			problems = append(problems, fmt.Sprintf("branch %v: %v", branch, err))
+			continue
+		}
+
+		// Code "branch" must be the first entry, as expected by edit page
+		clean := []Notification{}
+		for _, n := range notifications {
+			if format < notificationFormat {
+				n.Text = html.UnescapeString(n.Text)
+			}
+			cleaned, valid := cleanNotification(n)
+			if !valid {
+				problems = append(problems, fmt.Sprintf("branch %v: invalid code %q", branch, n.Code))
+				continue
+			}
+			if cleaned.Code == "branch" {
+				clean = append([]Notification{cleaned}, clean...)
+			} else {
+				clean = append(clean, cleaned)
+			}
+		}
+		imported[branch] = clean
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("nothing imported.\n%v", strings.Join(problems, "\n"))
+	}
+
+	openNotificationStore()
+	for branch, notifications := range imported {
+		notificationViper.Set(branch, notifications)
+	}
+	notificationViper.Set("format", notificationFormat)
+	if err := notificationViper.WriteConfig(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "notifications of %v branch(es) imported to %v\n", len(imported), Paths.Notification)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPasswordCommand(t *testing.T) {
+	var out bytes.Buffer
+	if err := runCommand("hash-password", []string{"-cost", "4"}, strings.NewReader("rahasia\n"), &out); err != nil {
+		t.Fatal(err)
+	}
+	hash := strings.TrimSpace(out.String())
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte("rahasia")); err != nil {
+		t.Errorf("hash doesn't match password. %v", err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(hash)); cost != 4 {
+		t.Errorf("wrong cost: get %v want 4", cost)
+	}
+
+	if err := runCommand("hash-password", nil, strings.NewReader("\n"), &out); err == nil {
+		t.Errorf("empty password is hashed")
+	}
+	if err := runCommand("hash-passwd", nil, nil, &out); err == nil {
+		t.Errorf("unknown command is accepted")
+	}
+}
+
+func TestNotificationsExportImport(t *testing.T) {
+	defer func(path string) { Paths.Notification = path }(Paths.Notification)
+	dir := t.TempDir()
+	Paths.Notification = filepath.Join(dir, "notification.json")
+
+	loadCommandConfig()
+	defer InitLogger(defaultLogSettings())
+	branch := AppConfig.Branches[0].Code
+	other := AppConfig.Branches[1].Code
+	os.WriteFile(Paths.Notification, []byte(`{"format": 2, "`+other+`": [{"code": "branch", "text": "tetap"}]}`), 0644)
+
+	type Test struct {
+		name    string
+		content string
+		valid   bool
+	}
+
+	tests := []Test{
+		{name: "unknown branch", content: `{"xyz": [{"code": "branch", "text": "halo"}]}`},
+		{name: "invalid code", content: `{"` + branch + `": [{"code": "a1", "text": "halo"}]}`},
+		{name: "not JSON", content: `halo`},
+		{name: "format isn't a number", content: `{"format": "2", "` + branch + `": [{"code": "branch", "text": "Jam &amp; buka"}]}`},
+		{
+			name:    "legacy export",
+			content: `{"` + branch + `": [{"code": "A", "text": "Poli &amp; Lab <script>alert(1)</script>"}, {"code": "branch", "text": "Jam buka"}]}`,
+			valid:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		file := filepath.Join(dir, "import.json")
+		os.WriteFile(file, []byte(tt.content), 0644)
+
+		var out bytes.Buffer
+		err := runCommand("notifications", []string{"import", file}, nil, &out)
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("case %v: wrong result: get %v want valid %v", tt.name, err, tt.valid)
+		}
+	}
+
+	branchText, roomText := GetNotification(branch, "A")
+	if branchText != "Jam buka" || roomText != "Poli & Lab " {
+		t.Errorf("wrong imported notification: get %q %q", branchText, roomText)
+	}
+	if otherText, _ := GetNotification(other, ""); otherText != "tetap" {
+		t.Errorf("branch not in import is changed: get %q", otherText)
+	}
+
+	// Branch notification is moved to first entry
+	var out bytes.Buffer
+	if err := runCommand("notifications", []string{"export"}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	exported := out.String()
+	if !strings.Contains(exported, `"format": 2`) || strings.Index(exported, "Jam buka") > strings.Index(exported, "Poli") {
+		t.Errorf("wrong export: %v", exported)
+	}
+
+	// Exported file can be imported back unchanged
+	file := filepath.Join(dir, "export.json")
+	if err := runCommand("notifications", []string{"export", "-o", file}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand("notifications", []string{"import", file}, nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if branchText, roomText := GetNotification(branch, "A"); branchText != "Jam buka" || roomText != "Poli & Lab " {
+		t.Errorf("wrong notification after import of export: get %q %q", branchText, roomText)
+	}
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
+	"path"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const MAX_ROOM int = 10
@@ -18,8 +24,11 @@ type SessionKey struct {
 type RoomData struct {
 	Name      string `mapstructure:"name"`
 	GroupCode string `mapstructure:"group-code"`
-	// Code      []string `mapstructure:"code"`
-	Order int `mapstructure:"order"`
+	// Other group codes HIS logs for the same room. "*" and "?" are wildcards, e.g. "POLI*"
+	Code  []string `mapstructure:"code"`
+	Order int      `mapstructure:"order"`
+	// Minutes a patient may stay in room before alert is raised. 0 means no alert
+	AlertAfter int `mapstructure:"alert-after"`
 }
 
 type BranchData struct {
@@ -27,59 +36,327 @@ type BranchData struct {
 	Code     string `mapstructure:"code"`
 	ID       string `mapstructure:"id"`
 	Password string `mapstructure:"password"`
+	// Optional own HIS database: either full DSN, or name of profile in "database" section
+	DSN      string `mapstructure:"dsn"`
+	Database string `mapstructure:"database"`
 }
 
+type DatabaseProfile struct {
+	Address  string `mapstructure:"address"`
+	Name     string `mapstructure:"name"`
+	User     string `mapstructure:"user"`
+	Password string `mapstructure:"password"`
+}
+
+// Changes to room list of a process at one branch. Rooms are referred by group code, case insensitive
+type RoomOverride struct {
+	Disabled  bool                `mapstructure:"disabled"`   // branch doesn't serve the process at all
+	Hide      []string            `mapstructure:"hide"`       // rooms the branch doesn't have
+	Rename    map[string]string   `mapstructure:"rename"`     // group code -> room name at the branch
+	Order     []string            `mapstructure:"order"`      // rooms shown first, in this order. The rest keep their order after them
+	GroupCode map[string][]string `mapstructure:"group-code"` // group code -> other codes (or patterns) the branch logs for the room
+}
+
+// Rooms of a process as shown at a branch
+type RoomSet struct {
+	Rooms      []RoomData
+	RoomMap    map[string]*RoomData // room code (lowercase) -> room
+	Patterns   []RoomPattern        // checked in order when no room code matches
+	OtherCodes []string             // codes of branch "group-code" override, as written in config
+}
+
+// Wildcard group code of a room
+type RoomPattern struct {
+	Pattern string // lowercase
+	Room    *RoomData
+}
+
+// Profile built from DB_* keys in config.env
+const defaultDatabaseProfile = "default"
+
 type Config struct {
 	IsDev bool
 
 	Branches []BranchData
 	Rooms    map[string][]RoomData
 	RoomMap  map[string]map[string]*RoomData //process code -> room code
+	// Wildcard codes of rooms: process code -> patterns
+	RoomPatterns map[string][]RoomPattern
+	// Room list of branches with override in config.json: branch code -> process code. Use BranchRoomSet
+	BranchRooms map[string]map[string]RoomSet
 
 	DatabaseAddr string
 	DatabaseUser string
 	DatabasePswd string
 	DatabaseName string
+	Schema       SchemaData
+
+	DatabaseProfiles       map[string]DatabaseProfile
+	DatabaseCheckInterval  time.Duration
+	DatabaseQueryTimeout   time.Duration
+	DatabaseHistoryTimeout time.Duration // history reads of reports and statistics span many days
+
+	// Circuit breaker of each database: open after consecutive failures, retry after cooldown
+	BreakerFailures int
+	BreakerCooldown time.Duration
+
+	Log LogSettings
+
+	// Tracing: exporter none, stdout or otlp (HTTP to local collector)
+	TraceExporter     string
+	TraceOTLPEndpoint string
+	TraceSampleRatio  float64
+
+	// Checksum of config.env and config.json, reported by /version
+	Checksum string
 
 	PrimaryKey   SessionKey
 	SecondaryKey SessionKey
 	Port         string
+
+	// TLS is enabled when both cert and key are set. Files are checked for change every TLSReloadInterval.
+	// Plain HTTP on HTTPRedirectPort (optional) is redirected to Port
+	TLSCertFile       string
+	TLSKeyFile        string
+	TLSReloadInterval time.Duration
+	HTTPRedirectPort  string
+
+	// Security headers of public pages and /kmn-internal. HSTS is only sent over TLS
+	SecurityPublic   SecurityPolicy
+	SecurityInternal SecurityPolicy
+	HSTSMaxAge       time.Duration
+
+	// Graceful shutdown: readiness fails for ShutdownDelay, then requests are drained within ShutdownTimeout
+	ShutdownDelay   time.Duration
+	ShutdownTimeout time.Duration
+
+	// Room duration statistics (used for completion estimate)
+	StatsInterval    time.Duration
+	StatsHistoryDays int
+	StatsFile        string
+
+	// Waiting-room board. Refresh interval also applies to staff dashboard
+	BoardRefresh      time.Duration
+	BoardRecentCount  int
+	BoardRecentWindow time.Duration
+	// Waiting patient of staff dashboard without next room for this long is assumed done
+	DashboardWaitingCutoff time.Duration
+
+	// How long computed report is kept before recomputed
+	ReportCacheTTL time.Duration
+
+	// How long result of a queue lookup is reused
+	QueueCacheTTL time.Duration
+
+	// Snapshot mode: lookups are served from periodically polled branch snapshot instead of per-request query
+	SnapshotMode         bool
+	SnapshotInterval     time.Duration
+	SnapshotFullInterval time.Duration
+	SnapshotIncremental  bool
+	SnapshotLookback     time.Duration // incremental fetch starts this long before latest log, for rows written late with earlier time
+	SnapshotStaleAfter   time.Duration
+
+	// Dwell-time alert
+	AlertInterval   time.Duration
+	AlertChannels   []string
+	AlertWebhookURL string
+	AlertSMTPAddr   string
+	AlertEmailFrom  string
+	AlertEmailTo    []string
+}
+
+// Problems found while loading config. Errors stop the app, warnings are only logged
+type ConfigProblems struct {
+	Errors   []string
+	Warnings []string
+}
+
+func (p *ConfigProblems) errorf(format string, v ...interface{}) {
+	p.Errors = append(p.Errors, fmt.Sprintf(format, v...))
 }
 
+func (p *ConfigProblems) warnf(format string, v ...interface{}) {
+	p.Warnings = append(p.Warnings, fmt.Sprintf(format, v...))
+}
+
+// Load config, logging every problem found before exiting on error
 func (cfg *Config) readConfig() {
-	viper.SetConfigFile("./config.env")
+	problems := cfg.loadConfig()
+	for _, warning := range problems.Warnings {
+		WarnLogger.Printf("config: %v", warning)
+	}
+	for _, problem := range problems.Errors {
+		ErrorLogger.Printf("config: %v", problem)
+	}
+	if len(problems.Errors) > 0 {
+		ErrorLogger.Fatalf("invalid config, %v error(s) found\n", len(problems.Errors))
+	}
+}
+
+func (cfg *Config) loadConfig() ConfigProblems {
+	var problems ConfigProblems
+
+	viper.SetConfigFile(Paths.ConfigEnv)
 	err := viper.ReadInConfig()
 	if err != nil {
-		ErrorLogger.Fatalf("fail to open config.env. %v\n", err)
+		problems.errorf("fail to open config.env %v. %v", Paths.ConfigEnv, err)
 	}
 
-	AppConfig.IsDev = viper.GetBool("ISDEV") //default value (if key not exist) is false
+	cfg.IsDev = viper.GetBool("ISDEV") //default value (if key not exist) is false
 
 	readEnvByteConfig("PRIMARY_SESSION_KEY_AUTH", &cfg.PrimaryKey.Auth, []byte("super-secret-key-auth-first"))
-	readEnvByteConfig("PRIMARY_SESSION_KEY_ENCRYPT", &cfg.PrimaryKey.Encrypt, []byte("super-secret-key-encrypt-first"))
+	readEnvByteConfig("PRIMARY_SESSION_KEY_ENCRYPT", &cfg.PrimaryKey.Encrypt, []byte("super-secret-key-encrypt-1st-key"))
 	readEnvByteConfig("SECONDARY_SESSION_KEY_AUTH", &cfg.SecondaryKey.Auth, []byte("super-secret-key-auth-second"))
-	readEnvByteConfig("SECONDARY_SESSION_KEY_ENCRYPT", &cfg.SecondaryKey.Encrypt, []byte("super-secret-key-encrypt-second"))
+	readEnvByteConfig("SECONDARY_SESSION_KEY_ENCRYPT", &cfg.SecondaryKey.Encrypt, []byte("super-secret-key-encrypt-2nd-key"))
+	cfg.validateSessionKeys(&problems)
+
+	var logLevel string
+	var logMaxSize int
+	readEnvStringConfig("LOG_FORMAT", &cfg.Log.Format, "json") // json or logfmt
+	readEnvStringConfig("LOG_LEVEL", &logLevel, "info")        // debug, info, warn or error
+	readEnvStringConfig("LOG_OUTPUT", &cfg.Log.Output, "file") // stdout, stderr or file
+	readEnvStringConfig("LOG_FILE", &cfg.Log.File, "./logs.txt")
+	if Paths.LogFile != "" {
+		cfg.Log.File = Paths.LogFile
+	}
+	readEnvIntConfig("LOG_MAX_SIZE_MB", &logMaxSize, 10)
+	readEnvDurationConfig("LOG_ROTATE_INTERVAL", &cfg.Log.RotateInterval, 24*time.Hour)
+	readEnvIntConfig("LOG_MAX_BACKUPS", &cfg.Log.MaxBackups, 7)
+	// Session key itself must not double as hash key, so default is derived from it under own label
+	readEnvByteConfig("LOG_HASH_KEY", &cfg.Log.HashKey, deriveKey(cfg.PrimaryKey.Auth, "log-hash-key"))
+	if viper.Get("LOG_HASH_KEY") == nil && !cfg.IsDev {
+		problems.warnf("LOG_HASH_KEY isn't set, key derived from PRIMARY_SESSION_KEY_AUTH is used")
+	}
+	cfg.Log.MaxSize = int64(logMaxSize) * 1024 * 1024
+	var valid bool
+	if cfg.Log.Level, valid = parseLogLevel(logLevel); !valid {
+		problems.errorf("invalid LOG_LEVEL %q. use debug, info, warn or error", logLevel)
+	}
+	if cfg.Log.Format != "json" && cfg.Log.Format != "logfmt" {
+		problems.errorf("invalid LOG_FORMAT %q. use json or logfmt", cfg.Log.Format)
+	}
+
+	readEnvStringConfig("TRACE_EXPORTER", &cfg.TraceExporter, "none")
+	readEnvStringConfig("TRACE_OTLP_ENDPOINT", &cfg.TraceOTLPEndpoint, "127.0.0.1:4318")
+	readEnvFloatConfig("TRACE_SAMPLE_RATIO", &cfg.TraceSampleRatio, 1)
+	if cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1 {
+		problems.errorf("invalid TRACE_SAMPLE_RATIO %v. use value between 0 and 1", cfg.TraceSampleRatio)
+	}
 
 	readEnvStringConfig("PORT", &cfg.Port, "8080")
+	readEnvDurationConfig("SHUTDOWN_DELAY", &cfg.ShutdownDelay, 5*time.Second)
+	readEnvDurationConfig("SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout, 30*time.Second)
+	readEnvStringConfig("TLS_CERT_FILE", &cfg.TLSCertFile, "")
+	readEnvStringConfig("TLS_KEY_FILE", &cfg.TLSKeyFile, "")
+	readEnvDurationConfig("TLS_RELOAD_INTERVAL", &cfg.TLSReloadInterval, time.Minute)
+	readEnvStringConfig("HTTP_REDIRECT_PORT", &cfg.HTTPRedirectPort, "")
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		problems.errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	readEnvStringConfig("CSP_MODE_PUBLIC", &cfg.SecurityPublic.CSPMode, CSPReportOnly) // enforce, report-only or off
+	readEnvStringConfig("CSP_MODE_INTERNAL", &cfg.SecurityInternal.CSPMode, CSPReportOnly)
+	readEnvStringConfig("FRAME_ANCESTORS_PUBLIC", &cfg.SecurityPublic.FrameAncestors, "'none'")
+	readEnvStringConfig("FRAME_ANCESTORS_INTERNAL", &cfg.SecurityInternal.FrameAncestors, "'none'")
+	readEnvStringConfig("REFERRER_POLICY_PUBLIC", &cfg.SecurityPublic.ReferrerPolicy, "strict-origin-when-cross-origin")
+	readEnvStringConfig("REFERRER_POLICY_INTERNAL", &cfg.SecurityInternal.ReferrerPolicy, "no-referrer")
+	readEnvDurationConfig("HSTS_MAX_AGE", &cfg.HSTSMaxAge, 365*24*time.Hour)
+	for _, mode := range []string{cfg.SecurityPublic.CSPMode, cfg.SecurityInternal.CSPMode} {
+		if mode != CSPEnforce && mode != CSPReportOnly && mode != CSPOff {
+			problems.errorf("invalid CSP mode %q. use enforce, report-only or off", mode)
+		}
+	}
+
 	readEnvStringConfig("DB_ADDRESS", &cfg.DatabaseAddr, "127.0.0.1:3030")
 	readEnvStringConfig("DB_NAME", &cfg.DatabaseName, "kmn_queue")
 	readEnvStringConfig("DB_USER", &cfg.DatabaseUser, "root")
 	readEnvStringConfig("DB_PASSWORD", &cfg.DatabasePswd, "")
+	readEnvDurationConfig("DB_CHECK_INTERVAL", &cfg.DatabaseCheckInterval, 30*time.Second)
+	readEnvDurationConfig("DB_QUERY_TIMEOUT", &cfg.DatabaseQueryTimeout, 5*time.Second)
+	readEnvDurationConfig("DB_HISTORY_TIMEOUT", &cfg.DatabaseHistoryTimeout, 2*time.Minute)
+	readEnvIntConfig("BREAKER_FAILURES", &cfg.BreakerFailures, 5)
+	readEnvDurationConfig("BREAKER_COOLDOWN", &cfg.BreakerCooldown, 30*time.Second)
+
+	readEnvDurationConfig("STATS_INTERVAL", &cfg.StatsInterval, 24*time.Hour)
+	readEnvIntConfig("STATS_HISTORY_DAYS", &cfg.StatsHistoryDays, 30)
+	readEnvStringConfig("STATS_FILE", &cfg.StatsFile, "./stats.json")
+
+	readEnvDurationConfig("BOARD_REFRESH", &cfg.BoardRefresh, 10*time.Second)
+	readEnvIntConfig("BOARD_RECENT_COUNT", &cfg.BoardRecentCount, 5)
+	readEnvDurationOrZeroConfig("BOARD_RECENT_WINDOW", &cfg.BoardRecentWindow, 30*time.Minute, &problems)      // 0 shows recent patients of the whole day
+	readEnvDurationOrZeroConfig("DASHBOARD_WAITING_CUTOFF", &cfg.DashboardWaitingCutoff, time.Hour, &problems) // 0 keeps waiting patients for the whole day
+
+	readEnvDurationConfig("REPORT_CACHE_TTL", &cfg.ReportCacheTTL, time.Hour)
+	readEnvDurationConfig("QUEUE_CACHE_TTL", &cfg.QueueCacheTTL, 15*time.Second)
+
+	cfg.SnapshotMode = viper.GetBool("SNAPSHOT_MODE")
+	readEnvDurationConfig("SNAPSHOT_INTERVAL", &cfg.SnapshotInterval, 15*time.Second)
+	readEnvDurationConfig("SNAPSHOT_FULL_INTERVAL", &cfg.SnapshotFullInterval, 10*time.Minute)
+	readEnvBoolConfig("SNAPSHOT_INCREMENTAL", &cfg.SnapshotIncremental, true)
+	readEnvDurationConfig("SNAPSHOT_LOOKBACK", &cfg.SnapshotLookback, 5*time.Minute)
+	readEnvDurationConfig("SNAPSHOT_STALE_AFTER", &cfg.SnapshotStaleAfter, time.Minute)
+
+	var alertChannels, alertEmailTo string
+	readEnvDurationConfig("ALERT_INTERVAL", &cfg.AlertInterval, time.Minute)
+	readEnvStringConfig("ALERT_CHANNELS", &alertChannels, "log") // comma separated: log, webhook, email
+	readEnvStringConfig("ALERT_WEBHOOK_URL", &cfg.AlertWebhookURL, "")
+	readEnvStringConfig("ALERT_SMTP_ADDRESS", &cfg.AlertSMTPAddr, "127.0.0.1:25")
+	readEnvStringConfig("ALERT_EMAIL_FROM", &cfg.AlertEmailFrom, "antrian@localhost")
+	readEnvStringConfig("ALERT_EMAIL_TO", &alertEmailTo, "") // comma separated
+	cfg.AlertChannels = splitList(alertChannels)
+	cfg.AlertEmailTo = splitList(alertEmailTo)
 
 	// Read configuration file
-	viper.SetConfigFile("./config.json")
+	viper.SetConfigFile(Paths.ConfigJSON)
 	err = viper.ReadInConfig()
 	if err != nil {
-		ErrorLogger.Fatalf("fail to open config.json. %v\n", err)
+		problems.errorf("fail to open config.json %v. %v", Paths.ConfigJSON, err)
+		return problems
 	}
 
-	// Read branch configuration
+	// Read branch configuration. Decoding into existing slice would keep its extra entries
+	cfg.Branches = nil
 	err = viper.UnmarshalKey("branch", &cfg.Branches)
 	if err != nil {
-		ErrorLogger.Fatalf("fail to load branch info from config. %v\n", err)
+		problems.errorf("fail to load branch info from config. %v", err)
 	}
 	if len(cfg.Branches) == 0 {
-		ErrorLogger.Fatalln("no branch endpoint defined in config (possible corrupted file).")
+		problems.errorf("no branch endpoint defined in config (possible corrupted file).")
+	}
+	cfg.validateBranches(&problems)
+
+	// Read database profiles for branches with their own HIS database
+	cfg.DatabaseProfiles = nil
+	err = viper.UnmarshalKey("database", &cfg.DatabaseProfiles)
+	if err != nil {
+		problems.errorf("fail to load database profile from config. %v", err)
+	}
+	if cfg.DatabaseProfiles == nil {
+		cfg.DatabaseProfiles = make(map[string]DatabaseProfile)
+	}
+	if _, exist := cfg.DatabaseProfiles[defaultDatabaseProfile]; exist {
+		problems.errorf("database profile %q is reserved for DB_* keys in config.env", defaultDatabaseProfile)
+	}
+	cfg.DatabaseProfiles[defaultDatabaseProfile] = DatabaseProfile{
+		Address:  cfg.DatabaseAddr,
+		Name:     cfg.DatabaseName,
+		User:     cfg.DatabaseUser,
+		Password: cfg.DatabasePswd,
+	}
+	for _, branch := range cfg.Branches {
+		if _, exist := cfg.DatabaseProfiles[branch.Database]; branch.Database != "" && !exist {
+			problems.errorf("branch %v refers to undefined database profile %q", branch.Code, branch.Database)
+		}
+	}
+
+	// Read database schema mapping. Missing value falls back to original HIS schema
+	err = viper.UnmarshalKey("schema", &cfg.Schema)
+	if err != nil {
+		problems.errorf("fail to load schema mapping from config. %v", err)
+	}
+	cfg.Schema.setDefault()
+	if err := cfg.Schema.validate(); err != nil {
+		problems.errorf("invalid schema mapping in config. %v", err)
 	}
 
 	// Read room configuration
@@ -87,9 +364,17 @@ func (cfg *Config) readConfig() {
 	cfg.RoomMap = make(map[string]map[string]*RoomData)
 	cfg.RoomMap["opr"] = make(map[string]*RoomData)
 	cfg.RoomMap["pol"] = make(map[string]*RoomData)
+	cfg.RoomPatterns = make(map[string][]RoomPattern)
+	cfg.BranchRooms = make(map[string]map[string]RoomSet)
+
+	cfg.readRoomConfig("opr", &problems)
+	cfg.readRoomConfig("pol", &problems)
 
-	cfg.readRoomConfig("opr")
-	cfg.readRoomConfig("pol")
+	cfg.Checksum, err = configChecksum(Paths.ConfigEnv, Paths.ConfigJSON)
+	if err != nil {
+		ErrorLogger.Printf("fail to compute config checksum. %v\n", err)
+	}
+	return problems
 }
 
 func readEnvByteConfig(key string, dest *[]byte, default_value []byte) {
@@ -97,7 +382,7 @@ func readEnvByteConfig(key string, dest *[]byte, default_value []byte) {
 		*dest = []byte(temp.(string))
 	} else {
 		*dest = default_value
-		InfoLogger.Printf("%v is set with default value.\n", key)
+		DebugLogger.Printf("%v is set with default value.\n", key)
 	}
 }
 
@@ -106,19 +391,82 @@ func readEnvStringConfig(key string, dest *string, default_value string) {
 		*dest = temp
 	} else {
 		*dest = default_value
-		InfoLogger.Printf("%v is set with default value.\n", key)
+		DebugLogger.Printf("%v is set with default value.\n", key)
+	}
+}
+
+func readEnvIntConfig(key string, dest *int, default_value int) {
+	if temp := viper.GetInt(key); temp > 0 {
+		*dest = temp
+	} else {
+		*dest = default_value
+		DebugLogger.Printf("%v is set with default value.\n", key)
+	}
+}
+
+// Split comma separated config value, ignoring empty entry
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// Duration is written in Go format, e.g. "30m" or "24h"
+func readEnvDurationConfig(key string, dest *time.Duration, default_value time.Duration) {
+	if temp := viper.GetDuration(key); temp > 0 {
+		*dest = temp
+	} else {
+		*dest = default_value
+		DebugLogger.Printf("%v is set with default value.\n", key)
+	}
+}
+
+// Like readEnvDurationConfig, but 0 is kept (e.g. to turn limit off) and invalid value is reported
+func readEnvDurationOrZeroConfig(key string, dest *time.Duration, default_value time.Duration, problems *ConfigProblems) {
+	*dest = default_value
+	if !viper.IsSet(key) {
+		DebugLogger.Printf("%v is set with default value.\n", key)
+		return
+	}
+	if value, err := time.ParseDuration(viper.GetString(key)); err != nil || value < 0 {
+		problems.errorf("invalid %v %q. use duration like 30m, or 0", key, viper.GetString(key))
+	} else {
+		*dest = value
+	}
+}
+
+func readEnvFloatConfig(key string, dest *float64, default_value float64) {
+	if viper.IsSet(key) {
+		*dest = viper.GetFloat64(key)
+	} else {
+		*dest = default_value
+		DebugLogger.Printf("%v is set with default value.\n", key)
+	}
+}
+
+func readEnvBoolConfig(key string, dest *bool, default_value bool) {
+	if viper.IsSet(key) {
+		*dest = viper.GetBool(key)
+	} else {
+		*dest = default_value
+		DebugLogger.Printf("%v is set with default value.\n", key)
 	}
 }
 
 // Helper function to simplify room config assignment for each process
-func (cfg *Config) readRoomConfig(process string) {
+func (cfg *Config) readRoomConfig(process string, problems *ConfigProblems) {
 	var rooms []RoomData
 	var key string
 
 	key = fmt.Sprintf("process.%s.room", process)
 	err := viper.UnmarshalKey(key, &rooms)
 	if err != nil {
-		ErrorLogger.Fatalf("fail to load room info from config. %v\n", err)
+		problems.errorf("fail to load room info of %v from config. %v", process, err)
+		return
 	}
 	// Limit the number of visible room regardless of config file
 	// (hard-coded limitation for Released application)
@@ -127,24 +475,171 @@ func (cfg *Config) readRoomConfig(process string) {
 	if roomCount < 0 {
 		roomCount = 0
 	} else if roomCount > MAX_ROOM {
+		problems.warnf("%v: visible-room %v is over the limit, only %v rooms are shown", process, roomCount, MAX_ROOM)
 		roomCount = MAX_ROOM
 	}
+	if roomCount > len(rooms) {
+		problems.warnf("%v: visible-room %v is more than the %v rooms defined", process, roomCount, len(rooms))
+		roomCount = len(rooms)
+	}
+	placeholders := 0
+	for _, room := range rooms[roomCount:] {
+		if room.Name == "" && room.GroupCode == "" {
+			placeholders++
+		} else {
+			problems.warnf("%v: room %q is hidden by visible-room %v", process, room.Name, roomCount)
+		}
+	}
+	if placeholders > 0 {
+		problems.warnf("%v: %v empty placeholder room(s) after visible-room can be removed", process, placeholders)
+	}
 	rooms = rooms[:roomCount] //prune
 
 	// Validate data
 	if len(rooms) == 0 {
-		ErrorLogger.Fatalln("missing room list defined in config (possible corrupted or excessive prune).")
+		problems.errorf("%v: missing room list defined in config (possible corrupted or excessive prune).", process)
+		return
 	}
+	validateRooms(process, rooms, problems)
+	set := newRoomSet(process, rooms, problems)
+	set.warnOverlaps(process, problems)
 
 	// Save to persisted vars
 	cfg.Rooms[process] = make([]RoomData, len(rooms))
 	copy(cfg.Rooms[process], rooms)
-	for i := 0; i < len(rooms); i++ {
-		// Standardize key: lowercase
-		group_code := strings.ToLower(rooms[i].GroupCode)
+	cfg.RoomMap[process] = set.RoomMap
+	cfg.RoomPatterns[process] = set.Patterns
+
+	cfg.readRoomOverrides(process, problems)
+}
+
+// Branches that differ from room list of the process, e.g. without laboratory or with own room names.
+// Resolved once, so lookup only picks the room set of the branch
+func (cfg *Config) readRoomOverrides(process string, problems *ConfigProblems) {
+	var overrides map[string]RoomOverride
+	key := fmt.Sprintf("process.%s.branch", process)
+	if err := viper.UnmarshalKey(key, &overrides); err != nil {
+		problems.errorf("fail to load branch room override of %v from config. %v", process, err)
+		return
+	}
+
+	for branch, override := range overrides {
+		if !cfg.validateBranch(branch) {
+			problems.errorf("%v: room override for unknown branch %q", process, branch)
+			continue
+		}
+		if cfg.BranchRooms[branch] == nil {
+			cfg.BranchRooms[branch] = make(map[string]RoomSet)
+		}
+		cfg.BranchRooms[branch][process] = resolveRoomOverride(process, branch, cfg.Rooms[process], override, problems)
+	}
+}
+
+// Codes and patterns to query from HIS, including other codes of the branch
+func (set RoomSet) GroupCodes() []string {
+	var codes []string
+	for _, room := range set.Rooms {
+		if room.GroupCode != "" {
+			codes = append(codes, room.GroupCode)
+		}
+		codes = append(codes, room.Code...)
+	}
+	return append(codes, set.OtherCodes...)
+}
 
-		cfg.RoomMap[process][group_code] = &rooms[i]
+// Room of a group code logged by HIS. Room code takes precedence over patterns, which are checked in config order
+func (set RoomSet) Room(group string) (*RoomData, bool) {
+	group = strings.ToLower(group)
+	if room, exist := set.RoomMap[group]; exist {
+		return room, true
+	}
+	for _, p := range set.Patterns {
+		if matched, _ := path.Match(p.Pattern, group); matched {
+			return p.Room, true
+		}
 	}
+	return nil, false
+}
+
+func isGroupPattern(code string) bool {
+	return strings.ContainsAny(code, "*?")
+}
+
+// Room codes and patterns of rooms. Rooms must not be appended to afterwards, as the set points to them
+func newRoomSet(where string, rooms []RoomData, problems *ConfigProblems) RoomSet {
+	set := RoomSet{Rooms: rooms, RoomMap: make(map[string]*RoomData)}
+	for i := range rooms {
+		set.addCodes(where, &rooms[i], append([]string{rooms[i].GroupCode}, rooms[i].Code...), problems)
+	}
+	return set
+}
+
+func (set *RoomSet) addCodes(where string, room *RoomData, codes []string, problems *ConfigProblems) {
+	for _, code := range codes {
+		key := strings.ToLower(code)
+		if key == "" {
+			continue
+		}
+		// Pattern is also sent to HIS as LIKE, so only * and ? are supported
+		if strings.ContainsAny(key, `[]\`) {
+			problems.errorf("%v: group-code %q of room %q may only use * and ? as wildcard", where, code, room.Name)
+			continue
+		}
+
+		if !isGroupPattern(key) {
+			if other, exist := set.RoomMap[key]; exist {
+				problems.errorf("%v: group-code %q is used by both %q and %q", where, code, other.Name, room.Name)
+				continue
+			}
+			set.RoomMap[key] = room
+			continue
+		}
+
+		duplicate := false
+		for _, p := range set.Patterns {
+			if p.Pattern == key {
+				problems.errorf("%v: pattern %q is used by both %q and %q", where, code, p.Room.Name, room.Name)
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			set.Patterns = append(set.Patterns, RoomPattern{Pattern: key, Room: room})
+		}
+	}
+}
+
+// Room codes matching a pattern of other room are resolved to their own room, which may be unintended
+func (set RoomSet) warnOverlaps(where string, problems *ConfigProblems) {
+	for _, p := range set.Patterns {
+		for code, room := range set.RoomMap {
+			if matched, _ := path.Match(p.Pattern, code); matched && room != p.Room {
+				problems.warnf("%v: pattern %q of %q also matches group-code %q of %q, which is used for %q", where, p.Pattern, p.Room.Name, code, room.Name, room.Name)
+			}
+		}
+	}
+}
+
+// Room list of a process at a branch. Branch without override uses room list of the process
+func (cfg *Config) BranchRoomSet(branchCode, processCode string) RoomSet {
+	if set, exist := cfg.BranchRooms[branchCode][processCode]; exist {
+		return set
+	}
+	return RoomSet{Rooms: cfg.Rooms[processCode], RoomMap: cfg.RoomMap[processCode], Patterns: cfg.RoomPatterns[processCode]}
+}
+
+// Process is offered at a branch unless it's disabled or all of its rooms are hidden
+func (cfg *Config) SupportsProcess(branchCode, processCode string) bool {
+	return len(cfg.BranchRoomSet(branchCode, processCode).Rooms) > 0
+}
+
+func (cfg *Config) BranchProcesses(branchCode string) []ProcessData {
+	var processes []ProcessData
+	for _, process := range ProcessLibArr {
+		if cfg.SupportsProcess(branchCode, process.Code) {
+			processes = append(processes, process)
+		}
+	}
+	return processes
 }
 
 func (cfg *Config) getBranchInfo(branchCode string) (string, string) {
@@ -162,9 +657,10 @@ func (cfg *Config) getBranchInfo(branchCode string) (string, string) {
 	return branchName, branchID
 }
 
+var branchCodePattern = regexp.MustCompile(`^[a-z]{3}$`)
+
 func (cfg *Config) validateBranch(branchCode string) bool {
-	exp := regexp.MustCompile(`^[a-z]{3}$`)
-	if valid := exp.MatchString(branchCode); !valid {
+	if valid := branchCodePattern.MatchString(branchCode); !valid {
 		return false
 	}
 
@@ -175,3 +671,181 @@ func (cfg *Config) validateBranch(branchCode string) bool {
 	}
 	return false
 }
+
+//========================================================================//
+// ** Validation **//
+
+// Rooms of these processes are displayed by "order" instead of time (see ConstructRoomList)
+var orderedProcesses = map[string]bool{"opr": true}
+
+func validateRooms(process string, rooms []RoomData, problems *ConfigProblems) {
+	for i, room := range rooms {
+		if room.Name == "" || room.GroupCode == "" {
+			problems.errorf("%v: room #%v has empty name or group-code", process, i+1)
+		}
+		if room.AlertAfter < 0 {
+			problems.errorf("%v: room %q has negative alert-after %v", process, room.Name, room.AlertAfter)
+		}
+	}
+
+	if !orderedProcesses[process] {
+		return
+	}
+	n := len(rooms)
+	ordered := map[int]string{} // order -> room name
+	for _, room := range rooms {
+		if room.Order < 0 || room.Order >= n {
+			problems.errorf("%v: order %v of room %q is out of range 0-%v", process, room.Order, room.Name, n-1)
+			continue
+		}
+		if name, exist := ordered[room.Order]; exist {
+			problems.errorf("%v: order %v is used by both %q and %q", process, room.Order, name, room.Name)
+			continue
+		}
+		ordered[room.Order] = room.Name
+	}
+	for order := 0; order < n; order++ {
+		if _, exist := ordered[order]; !exist {
+			problems.warnf("%v: no room has order %v, it's always shown empty", process, order)
+		}
+	}
+}
+
+// Branch with invalid code is rejected by validateBranch, so it can never be selected
+func (cfg *Config) validateBranches(problems *ConfigProblems) {
+	codes := map[string]bool{}
+	ids := map[string]string{} // HIS id -> branch code
+	for _, branch := range cfg.Branches {
+		if !branchCodePattern.MatchString(branch.Code) {
+			problems.errorf("branch %q: code must be 3 lowercase letters", branch.Code)
+		}
+		if codes[branch.Code] {
+			problems.errorf("branch %q is defined more than once", branch.Code)
+		}
+		codes[branch.Code] = true
+
+		if branch.ID == "" {
+			problems.errorf("branch %v: empty id", branch.Code)
+		} else if code, exist := ids[branch.ID]; exist {
+			problems.warnf("branch %v and %v have the same id %q", code, branch.Code, branch.ID)
+		}
+		ids[branch.ID] = branch.Code
+
+		// Login compares bcrypt hash, so plain password never matches
+		if _, err := bcrypt.Cost([]byte(branch.Password)); err != nil {
+			problems.warnf("branch %v: password isn't a bcrypt hash, generate it with hash-password. %v", branch.Code, err)
+		}
+	}
+}
+
+// Encrypt key is used as AES key. Built-in keys are public, so sessions could be forged outside development
+func (cfg *Config) validateSessionKeys(problems *ConfigProblems) {
+	keys := []struct {
+		name    string
+		value   []byte
+		encrypt bool
+	}{
+		{"PRIMARY_SESSION_KEY_AUTH", cfg.PrimaryKey.Auth, false},
+		{"PRIMARY_SESSION_KEY_ENCRYPT", cfg.PrimaryKey.Encrypt, true},
+		{"SECONDARY_SESSION_KEY_AUTH", cfg.SecondaryKey.Auth, false},
+		{"SECONDARY_SESSION_KEY_ENCRYPT", cfg.SecondaryKey.Encrypt, true},
+	}
+	for _, key := range keys {
+		if viper.Get(key.name) == nil && !cfg.IsDev {
+			problems.warnf("%v isn't set, built-in default key is used", key.name)
+		}
+		if n := len(key.value); key.encrypt && n != 16 && n != 24 && n != 32 {
+			problems.errorf("%v must be 16, 24 or 32 bytes long, got %v", key.name, n)
+		}
+	}
+}
+
+// Labelled HMAC, so one secret gives independent keys for different purposes
+func deriveKey(secret []byte, label string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
+
+func resolveRoomOverride(process, branch string, base []RoomData, override RoomOverride, problems *ConfigProblems) RoomSet {
+	set := RoomSet{Rooms: []RoomData{}, RoomMap: make(map[string]*RoomData)}
+	if override.Disabled {
+		return set
+	}
+	where := fmt.Sprintf("%v at %v", process, branch)
+
+	// Ordered rooms are placed by order, so overrides apply to that order
+	rooms := make([]RoomData, len(base))
+	copy(rooms, base)
+	if orderedProcesses[process] {
+		sort.SliceStable(rooms, func(i, j int) bool { return rooms[i].Order < rooms[j].Order })
+	}
+	index := func(code string) int {
+		for i, room := range rooms {
+			if strings.EqualFold(room.GroupCode, code) {
+				return i
+			}
+		}
+		problems.errorf("%v: unknown room %q in override", where, code)
+		return -1
+	}
+
+	for code, name := range override.Rename {
+		if i := index(code); i != -1 {
+			rooms[i].Name = name
+		}
+	}
+
+	hidden := make(map[int]bool)
+	for _, code := range override.Hide {
+		if i := index(code); i != -1 {
+			hidden[i] = true
+		}
+	}
+
+	var listed []int
+	placed := make(map[int]bool)
+	for _, code := range override.Order {
+		i := index(code)
+		if i == -1 || placed[i] {
+			continue
+		}
+		if hidden[i] {
+			problems.errorf("%v: hidden room %q is in order", where, code)
+			continue
+		}
+		listed = append(listed, i)
+		placed[i] = true
+	}
+	for i := range rooms {
+		if !placed[i] && !hidden[i] {
+			listed = append(listed, i)
+		}
+	}
+
+	for _, i := range listed {
+		room := rooms[i]
+		if orderedProcesses[process] {
+			room.Order = len(set.Rooms)
+		}
+		set.Rooms = append(set.Rooms, room)
+	}
+	if len(set.Rooms) == 0 {
+		problems.warnf("%v: every room is hidden, process isn't offered. use \"disabled\" instead", where)
+		return set
+	}
+	validateRooms(where, set.Rooms, problems)
+
+	set = newRoomSet(where, set.Rooms, problems)
+	for code, extras := range override.GroupCode {
+		room, exist := set.RoomMap[strings.ToLower(code)]
+		if !exist {
+			problems.errorf("%v: unknown room %q in group-code", where, code)
+			continue
+		}
+		set.addCodes(where, room, extras, problems)
+		set.OtherCodes = append(set.OtherCodes, extras...)
+	}
+	set.warnOverlaps(where, problems)
+	return set
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validBranchJSON = `"branch": [{"code": "kmy", "name": "Kemayoran", "id": "kmn01", "password": "$2a$08$1R3Ti2oVbD7mywAEWVL1aOplpiHMive8q2o/bFPGC3MpzunRS0MGC"}]`
+const validPolJSON = `"pol": {"visible-room": 1, "room": [{"name": "Registrasi", "group-code": "REG"}]}`
+
+func TestLoadConfigProblems(t *testing.T) {
+	defer func(paths FilePaths) { Paths = paths }(Paths)
+	dir := t.TempDir()
+	Paths.ConfigEnv = filepath.Join(dir, "config.env")
+	Paths.ConfigJSON = filepath.Join(dir, "config.json")
+
+	type Test struct {
+		name     string
+		env      string
+		json     string
+		errors   []string
+		warnings []string
+	}
+
+	validOpr := `"opr": {"visible-room": 2, "room": [{"name": "Persiapan", "group-code": "PREOP", "order": 0}, {"name": "Tindakan", "group-code": "OT", "order": 1}]}`
+	tests := []Test{
+		{
+			name: "valid",
+			env:  "ISDEV=true",
+			json: `{` + validBranchJSON + `, "process": {` + validOpr + `, ` + validPolJSON + `}}`,
+		},
+		{
+			name:     "default secrets",
+			json:     `{` + validBranchJSON + `, "process": {` + validOpr + `, ` + validPolJSON + `}}`,
+			warnings: []string{"PRIMARY_SESSION_KEY_AUTH isn't set", "SECONDARY_SESSION_KEY_ENCRYPT isn't set", "LOG_HASH_KEY isn't set"},
+		},
+		{
+			name:   "invalid env values are all reported",
+			env:    "ISDEV=true\nPRIMARY_SESSION_KEY_ENCRYPT=short\nLOG_LEVEL=loud\nCSP_MODE_PUBLIC=strict\nBOARD_RECENT_WINDOW=soon",
+			json:   `{` + validBranchJSON + `, "process": {` + validOpr + `, ` + validPolJSON + `}}`,
+			errors: []string{"PRIMARY_SESSION_KEY_ENCRYPT must be 16, 24 or 32 bytes", `invalid LOG_LEVEL "loud"`, `invalid CSP mode "strict"`, `invalid BOARD_RECENT_WINDOW "soon"`},
+		},
+		{
+			name: "branches",
+			env:  "ISDEV=true",
+			json: `{"branch": [{"code": "KBJ", "name": "A", "id": "kmn02", "password": "rahasia"}, {"code": "kmy", "name": "B", "id": "kmn02", "password": "rahasia"}, {"code": "kmy", "name": "C", "id": "", "password": "rahasia"}],
+				"process": {` + validOpr + `, ` + validPolJSON + `}}`,
+			errors:   []string{`branch "KBJ": code must be 3 lowercase letters`, `branch "kmy" is defined more than once`, "branch kmy: empty id"},
+			warnings: []string{"branch KBJ: password isn't a bcrypt hash", `branch KBJ and kmy have the same id "kmn02"`},
+		},
+		{
+			name: "rooms",
+			env:  "ISDEV=true",
+			json: `{` + validBranchJSON + `, "process": {
+				"opr": {"visible-room": 3, "room": [{"name": "A", "group-code": "X", "order": 0}, {"name": "B", "group-code": "x", "order": 0}, {"name": "C", "group-code": "Y", "order": 3}, {"name": "D", "group-code": "Z", "order": 3}]},
+				"pol": {"visible-room": 2, "room": [{"name": "Registrasi", "group-code": "REG"}, {"name": "", "group-code": "RM"}, {"name": "", "group-code": ""}]}}}`,
+			errors: []string{
+				`opr: group-code "x" is used by both "A" and "B"`,
+				`opr: order 0 is used by both "A" and "B"`,
+				`opr: order 3 of room "C" is out of range 0-2`,
+				"pol: room #2 has empty name or group-code",
+			},
+			warnings: []string{`opr: room "D" is hidden by visible-room 3`, "opr: no room has order 1", "pol: 1 empty placeholder room(s)"},
+		},
+		{
+			name:     "visible-room over room count",
+			env:      "ISDEV=true",
+			json:     `{` + validBranchJSON + `, "process": {` + validOpr + `, "pol": {"visible-room": 3, "room": [{"name": "Registrasi", "group-code": "REG"}]}}}`,
+			warnings: []string{"pol: visible-room 3 is more than the 1 rooms defined"},
+		},
+		{
+			name:   "no rooms",
+			env:    "ISDEV=true",
+			json:   `{` + validBranchJSON + `, "process": {` + validOpr + `}}`,
+			errors: []string{"pol: missing room list"},
+		},
+	}
+
+	for _, tt := range tests {
+		os.WriteFile(Paths.ConfigEnv, []byte(tt.env), 0644)
+		os.WriteFile(Paths.ConfigJSON, []byte(tt.json), 0644)
+
+		var cfg Config
+		problems := cfg.loadConfig()
+		if string(cfg.Log.HashKey) == string(cfg.PrimaryKey.Auth) {
+			t.Errorf("case %v: session key is used as log hash key", tt.name)
+		}
+		if len(problems.Errors) != len(tt.errors) {
+			t.Errorf("case %v: wrong error count: get %q want %v", tt.name, problems.Errors, len(tt.errors))
+		}
+		for _, want := range tt.errors {
+			if !containsProblem(problems.Errors, want) {
+				t.Errorf("case %v: missing error %q. get %q", tt.name, want, problems.Errors)
+			}
+		}
+		if tt.warnings == nil && len(problems.Warnings) > 0 {
+			t.Errorf("case %v: unexpected warnings %q", tt.name, problems.Warnings)
+		}
+		for _, want := range tt.warnings {
+			if !containsProblem(problems.Warnings, want) {
+				t.Errorf("case %v: missing warning %q. get %q", tt.name, want, problems.Warnings)
+			}
+		}
+	}
+}
+
+func containsProblem(problems []string, want string) bool {
+	for _, problem := range problems {
+		if strings.Contains(problem, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBoardRecentWindowConfig(t *testing.T) {
+	defer func(paths FilePaths) { Paths = paths }(Paths)
+	dir := t.TempDir()
+	Paths.ConfigEnv = filepath.Join(dir, "config.env")
+	Paths.ConfigJSON = filepath.Join(dir, "config.json")
+	os.WriteFile(Paths.ConfigJSON, []byte(`{`+validBranchJSON+`, "process": {`+validPolJSON+`}}`), 0644)
+
+	// 0 turns window off instead of falling back to default
+	tests := map[string]time.Duration{
+		"":                        30 * time.Minute,
+		"BOARD_RECENT_WINDOW=0":   0,
+		"BOARD_RECENT_WINDOW=10m": 10 * time.Minute,
+	}
+	for env, want := range tests {
+		os.WriteFile(Paths.ConfigEnv, []byte("ISDEV=true\n"+env), 0644)
+
+		var cfg Config
+		cfg.loadConfig()
+		if cfg.BoardRecentWindow != want {
+			t.Errorf("case %q: wrong window: get %v want %v", env, cfg.BoardRecentWindow, want)
+		}
+	}
+}
+
+// Room with order outside room list is skipped instead of panicking
+func TestConstructRoomListBasedOnOrderOutOfRange(t *testing.T) {
+	defer func(rooms map[string][]RoomData, roomMap map[string]map[string]*RoomData) {
+		AppConfig.Rooms, AppConfig.RoomMap = rooms, roomMap
+	}(AppConfig.Rooms, AppConfig.RoomMap)
+
+	rooms := []RoomData{{Name: "A", GroupCode: "A", Order: 0}, {Name: "B", GroupCode: "B", Order: 3}, {Name: "C", GroupCode: "C", Order: -1}}
+	AppConfig.Rooms = map[string][]RoomData{"opr": rooms}
+	AppConfig.RoomMap = map[string]map[string]*RoomData{"opr": {"a": &rooms[0], "b": &rooms[1], "c": &rooms[2]}}
+
+	now := time.Now()
+	logs := []PatientLog{
+		{Group: "A", Status: "I", Time: now},
+		{Group: "B", Status: "I", Time: now.Add(time.Minute)},
+		{Group: "C", Status: "I", Time: now.Add(2 * time.Minute)},
+	}
+	get := ConstructRoomListBasedOnOrder(logs, "kmy", "opr")
+	if len(get) != 3 {
+		t.Fatalf("wrong room count: get %v want 3", len(get))
+	}
+	if get[0].Time == "-" || !get[0].IsActive {
+		t.Errorf("room with valid order isn't displayed: %+v", get[0])
+	}
+	if get[1].Time != "-" || get[2].Time != "-" {
+		t.Errorf("room with invalid order is displayed: %+v", get)
+	}
+}
+
+func TestBranchRoomOverride(t *testing.T) {
+	defer AppConfig.readConfig() // after paths are restored
+	defer func(paths FilePaths) { Paths = paths }(Paths)
+	dir := t.TempDir()
+	Paths.ConfigEnv = filepath.Join(dir, "config.env")
+	Paths.ConfigJSON = filepath.Join(dir, "config.json")
+	os.WriteFile(Paths.ConfigEnv, []byte("ISDEV=true"), 0644)
+
+	branches := `"branch": [
+		{"code": "kmy", "name": "Kemayoran", "id": "kmn01", "password": "$2a$08$1R3Ti2oVbD7mywAEWVL1aOplpiHMive8q2o/bFPGC3MpzunRS0MGC"},
+		{"code": "smg", "name": "Semarang", "id": "kmn05", "password": "$2a$08$1R3Ti2oVbD7mywAEWVL1aOplpiHMive8q2o/bFPGC3MpzunRS0MGC"}]`
+	opr := `"opr": {"visible-room": 3, "room": [
+		{"name": "Persiapan", "group-code": "PREOP", "order": 0}, {"name": "Tindakan", "group-code": "OT", "order": 1}, {"name": "Pemulihan", "group-code": "PREPOST", "order": 2}]`
+	pol := `"pol": {"visible-room": 3, "room": [{"name": "Registrasi", "group-code": "REG"}, {"name": "Refraksi", "group-code": "REF"}, {"name": "Ruang Konsul", "group-code": "POLI"}]`
+
+	// smg: no refraksi, own room name, extra code; pemulihan shown before tindakan
+	content := `{` + branches + `, "process": {` +
+		opr + `, "branch": {"smg": {"order": ["PREOP", "PREPOST"]}}}, ` +
+		pol + `, "branch": {"smg": {"hide": ["REF"], "rename": {"POLI": "Ruang Dokter"}, "group-code": {"POLI": ["POLI2"]}}}}}}`
+	os.WriteFile(Paths.ConfigJSON, []byte(content), 0644)
+
+	problems := AppConfig.loadConfig()
+	if len(problems.Errors) > 0 || len(problems.Warnings) > 0 {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+
+	type Test struct {
+		branch  string
+		process string
+		want    []RoomData
+	}
+
+	tests := []Test{
+		{
+			branch:  "kmy",
+			process: "pol",
+			want:    []RoomData{{Name: "Registrasi", GroupCode: "REG"}, {Name: "Refraksi", GroupCode: "REF"}, {Name: "Ruang Konsul", GroupCode: "POLI"}},
+		},
+		{
+			branch:  "smg",
+			process: "pol",
+			want:    []RoomData{{Name: "Registrasi", GroupCode: "REG"}, {Name: "Ruang Dokter", GroupCode: "POLI"}},
+		},
+		{
+			branch:  "smg",
+			process: "opr",
+			want:    []RoomData{{Name: "Persiapan", GroupCode: "PREOP", Order: 0}, {Name: "Pemulihan", GroupCode: "PREPOST", Order: 1}, {Name: "Tindakan", GroupCode: "OT", Order: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		get := AppConfig.BranchRoomSet(tt.branch, tt.process).Rooms
+		if !reflect.DeepEqual(get, tt.want) {
+			t.Errorf("case %v/%v: wrong rooms: get %+v want %+v", tt.branch, tt.process, get, tt.want)
+		}
+	}
+
+	// Other code of the branch resolves to the renamed room, hidden room isn't shown
+	now := time.Now()
+	logs := []PatientLog{
+		{Group: "REG", Status: "I", Time: now},
+		{Group: "REF", Status: "I", Time: now.Add(time.Minute)},
+		{Group: "POLI2", Status: "I", Time: now.Add(2 * time.Minute)},
+	}
+	rooms := ConstructRoomList(logs, "smg", "pol")
+	if len(rooms) != 2 || rooms[1].Name != "Ruang Dokter" || !rooms[1].IsActive {
+		t.Errorf("wrong room list at smg: %+v", rooms)
+	}
+	if codes := AppConfig.BranchRoomSet("smg", "pol").GroupCodes(); !reflect.DeepEqual(codes, []string{"REG", "POLI", "POLI2"}) {
+		t.Errorf("wrong group codes at smg: %v", codes)
+	}
+
+	// Disabled process isn't offered
+	content = `{` + branches + `, "process": {` + opr + `, "branch": {"smg": {"disabled": true}}}, ` + pol + `}}}`
+	os.WriteFile(Paths.ConfigJSON, []byte(content), 0644)
+	AppConfig.loadConfig()
+	if AppConfig.SupportsProcess("smg", "opr") || !AppConfig.SupportsProcess("kmy", "opr") {
+		t.Errorf("disabled process is offered")
+	}
+	if processes := AppConfig.BranchProcesses("smg"); len(processes) != 1 || processes[0].Code != "pol" {
+		t.Errorf("wrong processes at smg: %+v", processes)
+	}
+
+	// Invalid overrides are reported
+	content = `{` + branches + `, "process": {` + opr + `, "branch": {"xyz": {"disabled": true}}}, ` +
+		pol + `, "branch": {"smg": {"hide": ["LAB", "REF"], "order": ["REF"], "group-code": {"REG": ["POLI"]}}}}}}`
+	os.WriteFile(Paths.ConfigJSON, []byte(content), 0644)
+	problems = AppConfig.loadConfig()
+	for _, want := range []string{`opr: room override for unknown branch "xyz"`, `pol at smg: unknown room "LAB"`, `pol at smg: hidden room "REF" is in order`, `pol at smg: group-code "POLI" is used by both`} {
+		if !containsProblem(problems.Errors, want) {
+			t.Errorf("missing error %q. get %q", want, problems.Errors)
+		}
+	}
+}
+
+func TestRoomGroupCodes(t *testing.T) {
+	defer AppConfig.readConfig() // after paths are restored
+	defer func(paths FilePaths) { Paths = paths }(Paths)
+	dir := t.TempDir()
+	Paths.ConfigEnv = filepath.Join(dir, "config.env")
+	Paths.ConfigJSON = filepath.Join(dir, "config.json")
+	os.WriteFile(Paths.ConfigEnv, []byte("ISDEV=true"), 0644)
+
+	opr := `"opr": {"visible-room": 1, "room": [{"name": "Tindakan", "group-code": "OT", "order": 0}]}`
+	content := `{` + validBranchJSON + `, "process": {` + opr + `, "pol": {"visible-room": 3, "room": [
+		{"name": "Registrasi", "group-code": "REG", "code": ["REG2"]},
+		{"name": "Ruang Konsul", "group-code": "POLI", "code": ["POLI1", "POLI2", "KONSUL*"]},
+		{"name": "Laboratorium", "group-code": "LAB", "code": ["LAB?"]}]}}}`
+	os.WriteFile(Paths.ConfigJSON, []byte(content), 0644)
+
+	problems := AppConfig.loadConfig()
+	if len(problems.Errors) > 0 || len(problems.Warnings) > 0 {
+		t.Fatalf("unexpected problems: %+v", problems)
+	}
+
+	tests := map[string]string{
+		"REG":      "Registrasi",
+		"reg2":     "Registrasi",
+		"POLI2":    "Ruang Konsul",
+		"KONSUL-A": "Ruang Konsul",
+		"LAB1":     "Laboratorium",
+		"LAB12":    "",
+		"POLI3":    "",
+	}
+	set := AppConfig.BranchRoomSet("kmy", "pol")
+	for group, want := range tests {
+		get := ""
+		if room, valid := set.Room(group); valid {
+			get = room.Name
+		}
+		if get != want {
+			t.Errorf("case %v: wrong room: get %q want %q", group, get, want)
+		}
+	}
+	if codes := set.GroupCodes(); !reflect.DeepEqual(codes, []string{"REG", "REG2", "POLI", "POLI1", "POLI2", "KONSUL*", "LAB", "LAB?"}) {
+		t.Errorf("wrong group codes: %v", codes)
+	}
+
+	// Codes of the same room are shown as one room, unknown code is warned once
+	now := time.Now()
+	logs := []PatientLog{
+		{Group: "POLI1", Status: "I", Time: now},
+		{Group: "POLI2", Status: "O", Time: now.Add(time.Minute)},
+		{Group: "XRAY", Status: "I", Time: now.Add(2 * time.Minute)},
+	}
+	rooms := ConstructRoomList(logs, "kmy", "pol")
+	if len(rooms) != 1 || rooms[0].Name != "Ruang Konsul" || rooms[0].TimeOut == "-" {
+		t.Errorf("wrong room list: %+v", rooms)
+	}
+	if _, warned := unknownGroups.Load("kmy/xray"); !warned {
+		t.Errorf("unknown group isn't warned")
+	}
+	if _, warned := unknownGroups.Load("kmy/poli1"); warned {
+		t.Errorf("known group is warned")
+	}
+
+	// Invalid codes are reported
+	content = `{` + validBranchJSON + `, "process": {` + opr + `, "pol": {"visible-room": 3, "room": [
+		{"name": "Registrasi", "group-code": "REG", "code": ["POLI1", "LAB[12]"]},
+		{"name": "Ruang Konsul", "group-code": "POLI", "code": ["POLI1", "LAB*", "RE*"]},
+		{"name": "Laboratorium", "group-code": "LAB", "code": ["LAB*"]}]}}}`
+	os.WriteFile(Paths.ConfigJSON, []byte(content), 0644)
+	problems = AppConfig.loadConfig()
+	wantErrors := []string{
+		`pol: group-code "LAB[12]" of room "Registrasi" may only use * and ?`,
+		`pol: group-code "POLI1" is used by both "Registrasi" and "Ruang Konsul"`,
+		`pol: pattern "LAB*" is used by both "Ruang Konsul" and "Laboratorium"`,
+	}
+	if len(problems.Errors) != len(wantErrors) {
+		t.Errorf("wrong error count: get %q", problems.Errors)
+	}
+	for _, want := range wantErrors {
+		if !containsProblem(problems.Errors, want) {
+			t.Errorf("missing error %q. get %q", want, problems.Errors)
+		}
+	}
+	for _, want := range []string{`pol: pattern "re*" of "Ruang Konsul" also matches group-code "reg" of "Registrasi"`, `pol: pattern "lab*" of "Ruang Konsul" also matches group-code "lab" of "Laboratorium"`} {
+		if !containsProblem(problems.Warnings, want) {
+			t.Errorf("missing warning %q. get %q", want, problems.Warnings)
+		}
+	}
+}
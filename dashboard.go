@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+type OccupancyPatient struct {
+	ID      string `json:"id"`
+	Room    string `json:"room,omitempty"` // for waiting patient: the last room left
+	Since   string `json:"since"`
+	Minutes int    `json:"minutes"`
+}
+
+type OccupancyRoom struct {
+	Name     string             `json:"name"`
+	Patients []OccupancyPatient `json:"patients"` // longest stay first
+}
+
+type OccupancyProcess struct {
+	Code  string          `json:"code"`
+	Name  string          `json:"name"`
+	Rooms []OccupancyRoom `json:"rooms"`
+	// Patients with OUT record but no IN to the next room yet, up to DASHBOARD_WAITING_CUTOFF
+	Waiting []OccupancyPatient `json:"waiting"`
+}
+
+type DashboardPayload struct {
+	Processes   []OccupancyProcess `json:"processes"`
+	Alerts      []Alert            `json:"alerts"`
+	LastUpdated string             `json:"last-updated"`
+}
+
+// Where each patient currently is, based on the same room list shown to each patient
+func ConstructOccupancy(patients map[string][]PatientLog, branchCode, processCode string, now time.Time) OccupancyProcess {
+	occupancy := OccupancyProcess{
+		Code:    processCode,
+		Name:    ProcessLibMap[processCode],
+		Rooms:   []OccupancyRoom{},
+		Waiting: []OccupancyPatient{},
+	}
+
+	rooms := AppConfig.BranchRoomSet(branchCode, processCode).Rooms
+	index := make(map[string]int)
+	for _, room := range rooms {
+		if _, exist := index[room.Name]; exist {
+			continue
+		}
+
+		index[room.Name] = len(occupancy.Rooms)
+		occupancy.Rooms = append(occupancy.Rooms, OccupancyRoom{Name: room.Name, Patients: []OccupancyPatient{}})
+	}
+
+	for id, logs := range patients {
+		var roomDisplays []RoomDisplay
+		switch processCode {
+		case "opr":
+			roomDisplays = ConstructRoomListBasedOnOrder(logs, branchCode, processCode)
+		case "pol":
+			roomDisplays = ConstructRoomListBasedOnTime(logs, branchCode, processCode)
+		}
+
+		// Latest room: active one, else the last room with any record
+		latest := -1
+		for i, rd := range roomDisplays {
+			if rd.IsActive {
+				latest = i
+				break
+			}
+			if rd.Time != "-" || rd.TimeOut != "-" {
+				latest = i
+			}
+		}
+		if latest == -1 {
+			continue
+		}
+		rd := roomDisplays[latest]
+
+		if rd.IsActive {
+			i, exist := index[rd.Name]
+			if !exist {
+				continue
+			}
+
+			occupancy.Rooms[i].Patients = append(occupancy.Rooms[i].Patients, occupancyPatient(id, "", rd.Time, now))
+			continue
+		}
+
+		// Done once patient left the last room of process. Not every patient ends there (e.g. POL),
+		// so patient waiting longer than cutoff is assumed done too
+		if rd.TimeOut == "-" || rd.Name == rooms[len(rooms)-1].Name {
+			continue
+		}
+		waiting := occupancyPatient(id, rd.Name, rd.TimeOut, now)
+		if cutoff := AppConfig.DashboardWaitingCutoff; cutoff > 0 && time.Duration(waiting.Minutes)*time.Minute >= cutoff {
+			continue
+		}
+		occupancy.Waiting = append(occupancy.Waiting, waiting)
+	}
+
+	// Time is formatted as 15:04:05, so it can be compared as string
+	byLongestStay := func(p []OccupancyPatient) func(i, j int) bool {
+		return func(i, j int) bool {
+			return p[i].Since < p[j].Since || (p[i].Since == p[j].Since && p[i].ID < p[j].ID)
+		}
+	}
+	for _, room := range occupancy.Rooms {
+		sort.Slice(room.Patients, byLongestStay(room.Patients))
+	}
+	sort.Slice(occupancy.Waiting, byLongestStay(occupancy.Waiting))
+
+	return occupancy
+}
+
+func occupancyPatient(id, room, since string, now time.Time) OccupancyPatient {
+	p := OccupancyPatient{ID: id, Room: room, Since: since}
+
+	if t, err := time.Parse("15:04:05", since); err == nil {
+		p.Minutes = int(now.Sub(onDate(t, now)).Minutes())
+	}
+
+	return p
+}
+
+// Empty process means every process
+func BuildDashboardPayload(branchCode, processCode string) (DashboardPayload, error) {
+	patients, takenAt, err := LookupBranchQueueLogs(branchCode)
+	if err != nil {
+		return DashboardPayload{}, err
+	}
+
+	now := time.Now()
+	payload := DashboardPayload{
+		Processes:   []OccupancyProcess{},
+		LastUpdated: takenAt.Format("2006-01-02 15:04:05"),
+	}
+	for _, process := range AppConfig.BranchProcesses(branchCode) {
+		if processCode != "" && processCode != process.Code {
+			continue
+		}
+
+		payload.Processes = append(payload.Processes, ConstructOccupancy(patients, branchCode, process.Code, now))
+	}
+	payload.Alerts = listAlerts(branchCode, processCode)
+
+	return payload, nil
+}
+
+// Returns branch code of logged user and process filter, or false if request is rejected
+func validateDashboardRequest(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	branchCode, ok := requireInternalSession(w, r, "dashboard")
+	if !ok {
+		return "", "", false
+	}
+
+	process := r.URL.Query().Get("process")
+	if process != "" && !validateProcess(process) {
+		requestLogger(r, WarnLogger).Printf("kmn-internal: invalid dashboard process filter. got: %v", process)
+		httpError(w, r, "input proses tidak valid.", http.StatusBadRequest)
+		return "", "", false
+	}
+
+	return branchCode, process, true
+}
+
+func InternalDashboardHandler(w http.ResponseWriter, r *http.Request) {
+	branchCode, process, ok := validateDashboardRequest(w, r)
+	if !ok {
+		return
+	}
+	branchName, _ := AppConfig.getBranchInfo(branchCode)
+
+	// Data is filled by event stream
+	payload := map[string]interface{}{
+		"Branch":    branchName,
+		"Process":   process,
+		"Processes": AppConfig.BranchProcesses(branchCode),
+	}
+	if err := TemplateDashboard.Execute(w, payload); err != nil {
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for dashboard. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+	}
+}
+
+func InternalDashboardEventsHandler(w http.ResponseWriter, r *http.Request) {
+	branchCode, process, ok := validateDashboardRequest(w, r)
+	if !ok {
+		return
+	}
+
+	StreamFeed(w, r, "dashboard/"+branchCode+"/"+process, func() (interface{}, error) {
+		return BuildDashboardPayload(branchCode, process)
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConstructOccupancy(t *testing.T) {
+	now := time.Date(2021, 8, 24, 10, 0, 0, 0, time.UTC)
+	ltime := time.Date(0, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	patients := map[string][]PatientLog{
+		// in OT for 30 minutes
+		"A001": {
+			{Group: "PREOP", Time: ltime, Status: "I"},
+			{Group: "PREOP", Time: ltime.Add(time.Minute * 20), Status: "O"},
+			{Group: "OT", Time: ltime.Add(time.Minute * 30), Status: "I"},
+		},
+		// left PREOP 15 minutes ago
+		"A002": {
+			{Group: "PREOP", Time: ltime.Add(time.Minute * 10), Status: "I"},
+			{Group: "PREOP", Time: ltime.Add(time.Minute * 45), Status: "O"},
+		},
+		// done
+		"A003": {
+			{Group: "PREPOST", Time: ltime, Status: "I"},
+			{Group: "PREPOST", Time: ltime.Add(time.Minute * 10), Status: "O"},
+		},
+		// not OPR
+		"A004": {
+			{Group: "REG", Time: ltime, Status: "I"},
+		},
+	}
+
+	get := ConstructOccupancy(patients, "kmy", "opr", now)
+
+	wantRooms := []OccupancyRoom{
+		{Name: "Ruang Persiapan Tindakan", Patients: []OccupancyPatient{}},
+		{Name: "Ruang Tindakan", Patients: []OccupancyPatient{{ID: "A001", Since: "09:30:00", Minutes: 30}}},
+		{Name: "Ruang Pemulihan", Patients: []OccupancyPatient{}},
+	}
+	if !reflect.DeepEqual(get.Rooms, wantRooms) {
+		t.Errorf("wrong rooms: get %+v want %+v", get.Rooms, wantRooms)
+	}
+
+	wantWaiting := []OccupancyPatient{{ID: "A002", Room: "Ruang Persiapan Tindakan", Since: "09:45:00", Minutes: 15}}
+	if !reflect.DeepEqual(get.Waiting, wantWaiting) {
+		t.Errorf("wrong waiting: get %+v want %+v", get.Waiting, wantWaiting)
+	}
+}
+
+// Process without fixed room order: done after the last room, or after waiting longer than cutoff
+func TestConstructOccupancyPol(t *testing.T) {
+	cutoff := AppConfig.DashboardWaitingCutoff
+	t.Cleanup(func() { AppConfig.DashboardWaitingCutoff = cutoff })
+	AppConfig.DashboardWaitingCutoff = time.Hour
+
+	now := time.Date(2021, 8, 24, 10, 0, 0, 0, time.UTC)
+	ltime := time.Date(0, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	patients := map[string][]PatientLog{
+		// left Ruang Konsul 20 minutes ago
+		"A001": {
+			{Group: "REG", Time: ltime, Status: "I"},
+			{Group: "POLI", Time: ltime.Add(time.Minute * 60), Status: "I"},
+			{Group: "POLI", Time: ltime.Add(time.Minute * 100), Status: "O"},
+		},
+		// left Ruang Konsul 90 minutes ago, never came back
+		"A002": {
+			{Group: "POLI", Time: ltime.Add(time.Minute * 20), Status: "I"},
+			{Group: "POLI", Time: ltime.Add(time.Minute * 30), Status: "O"},
+		},
+		// left the last room
+		"A003": {
+			{Group: "PP", Time: ltime.Add(time.Minute * 90), Status: "I"},
+			{Group: "PP", Time: ltime.Add(time.Minute * 110), Status: "O"},
+		},
+	}
+
+	get := ConstructOccupancy(patients, "kmy", "pol", now)
+	wantWaiting := []OccupancyPatient{{ID: "A001", Room: "Ruang Konsul", Since: "09:40:00", Minutes: 20}}
+	if !reflect.DeepEqual(get.Waiting, wantWaiting) {
+		t.Errorf("wrong waiting: get %+v want %+v", get.Waiting, wantWaiting)
+	}
+
+	// Without cutoff, patient stays waiting
+	AppConfig.DashboardWaitingCutoff = 0
+	if get := ConstructOccupancy(patients, "kmy", "pol", now); len(get.Waiting) != 2 {
+		t.Errorf("wrong waiting without cutoff: %+v", get.Waiting)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Connection to HIS database. Branches with the same connection share one pool
+type dbPool struct {
+	Name     string // profile name, used in log and health report (DSN contains password)
+	DB       *sql.DB
+	Branches []string
+
+	// Result of latest ping
+	Up        bool
+	LastError string
+	CheckedAt time.Time
+
+	Breaker *circuitBreaker
+}
+
+type DatabaseHealth struct {
+	Profile   string    `json:"profile"`
+	Up        bool      `json:"up"`
+	LastError string    `json:"last-error,omitempty"`
+	CheckedAt time.Time `json:"checked-at"`
+	Breaker   string    `json:"breaker"`
+}
+
+var dbPools = struct {
+	sync.RWMutex
+	byDSN    map[string]*dbPool
+	byBranch map[string]*dbPool
+}{}
+
+// Dial timeout keeps unreachable branch database from holding requests (and workers) for long
+func (profile DatabaseProfile) dsn() string {
+	return fmt.Sprintf("%s:%s@tcp(%s)/%s?timeout=5s", profile.User, profile.Password, profile.Address, profile.Name)
+}
+
+// Branch's own DSN takes precedence over its profile. Branch without either uses default database in config.env
+func branchConnection(branch BranchData) (string, string) {
+	if branch.DSN != "" {
+		return "branch-" + branch.Code, branch.DSN
+	}
+
+	profile := branch.Database
+	if profile == "" {
+		profile = defaultDatabaseProfile
+	}
+	return profile, AppConfig.DatabaseProfiles[profile].dsn()
+}
+
+// Open one pool per distinct connection, and verify schema mapping against each of them
+func OpenDatabases() {
+	dbPools.Lock()
+	defer dbPools.Unlock()
+
+	// Driver errors (e.g. broken connection) go to the same log
+	mysql.SetLogger(ErrorLogger)
+
+	dbPools.byDSN = make(map[string]*dbPool)
+	dbPools.byBranch = make(map[string]*dbPool)
+
+	for _, branch := range AppConfig.Branches {
+		name, dsn := branchConnection(branch)
+
+		pool, exist := dbPools.byDSN[dsn]
+		if !exist {
+			db, err := sql.Open("mysql", dsn)
+			if err != nil {
+				ErrorLogger.Fatalf("fail to open sql connection for database %v. %v", name, err)
+			}
+
+			pool = &dbPool{
+				Name:    name,
+				DB:      db,
+				Up:      true,
+				Breaker: newCircuitBreaker(name, AppConfig.BreakerFailures, AppConfig.BreakerCooldown),
+			}
+			dbPools.byDSN[dsn] = pool
+		}
+		pool.Branches = append(pool.Branches, branch.Code)
+		dbPools.byBranch[branch.Code] = pool
+	}
+
+	for _, pool := range dbPools.byDSN {
+		// Mismatched schema mapping would make every query fail, so refuse to start.
+		// If database is unreachable, it can't be checked yet, but app may still serve other branches
+		if err := AppConfig.Schema.Verify(pool.DB); err != nil {
+			if errors.Is(err, ErrSchemaMismatch) {
+				ErrorLogger.Fatalf("schema check failed for database %v. %v", pool.Name, err)
+			}
+			ErrorLogger.Printf("schema check skipped, database %v is unreachable. %v", pool.Name, err)
+		}
+		InfoLogger.Printf("database %v opened for branch %v", pool.Name, pool.Branches)
+	}
+}
+
+// Close every pool. Used on shutdown, after in-flight requests are drained
+func CloseDatabases() {
+	dbPools.Lock()
+	defer dbPools.Unlock()
+
+	for _, pool := range dbPools.byDSN {
+		if err := pool.DB.Close(); err != nil {
+			ErrorLogger.Printf("fail to close database %v. %v", pool.Name, err)
+		}
+	}
+}
+
+// Run query on database of given branch through its circuit breaker. Name is used as metrics label and span name.
+// Context is only used for tracing, query timeout is set by the query itself
+func queryBranch(ctx context.Context, branchCode, name string, query func(db *sql.DB) error) error {
+	dbPools.RLock()
+	pool, exist := dbPools.byBranch[branchCode]
+	dbPools.RUnlock()
+	if !exist {
+		return fmt.Errorf("no database opened for branch %v", branchCode)
+	}
+
+	if err := pool.Breaker.allow(time.Now()); err != nil {
+		queryRejected.WithLabelValues(branchCode, name).Inc()
+		return err
+	}
+
+	_, span := tracer.Start(ctx, "query "+name, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMySQL, attribute.String("branch", branchCode), attribute.String("db.profile", pool.Name)))
+	start := time.Now()
+	err := query(pool.DB)
+	if err == sql.ErrNoRows {
+		span.End()
+	} else {
+		endSpan(span, err)
+	}
+	queryDuration.WithLabelValues(branchCode, name).Observe(time.Since(start).Seconds())
+	if err != nil && err != sql.ErrNoRows {
+		queryErrors.WithLabelValues(branchCode, name).Inc()
+	}
+
+	pool.Breaker.record(err, time.Now())
+	return err
+}
+
+// Periodically ping every database, so outage of one branch database is reported on its own
+func StartDatabaseHealthCheck(interval time.Duration) {
+	go func() {
+		for {
+			checkDatabases()
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func checkDatabases() {
+	dbPools.RLock()
+	pools := make([]*dbPool, 0, len(dbPools.byDSN))
+	for _, pool := range dbPools.byDSN {
+		pools = append(pools, pool)
+	}
+	dbPools.RUnlock()
+
+	for _, pool := range pools {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := pool.DB.PingContext(ctx)
+		cancel()
+
+		dbPools.Lock()
+		wasUp := pool.Up
+		pool.Up = err == nil
+		pool.CheckedAt = time.Now()
+		pool.LastError = ""
+		if err != nil {
+			pool.LastError = err.Error()
+		}
+		dbPools.Unlock()
+
+		// Only log changes, to avoid flooding log during long outage
+		if wasUp && err != nil {
+			ErrorLogger.Printf("database %v (branch %v) is down. %v", pool.Name, pool.Branches, err)
+		} else if !wasUp && err == nil {
+			InfoLogger.Printf("database %v (branch %v) is up again", pool.Name, pool.Branches)
+		}
+	}
+}
+
+// Health of database used by each branch, keyed by branch code
+func BranchDatabaseHealth() map[string]DatabaseHealth {
+	dbPools.RLock()
+	defer dbPools.RUnlock()
+
+	health := make(map[string]DatabaseHealth)
+	for code, pool := range dbPools.byBranch {
+		health[code] = DatabaseHealth{
+			Profile:   pool.Name,
+			Up:        pool.Up,
+			LastError: pool.LastError,
+			CheckedAt: pool.CheckedAt,
+			Breaker:   pool.Breaker.State(),
+		}
+	}
+	return health
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Latest successful lookup of a patient, served with warning while database is unavailable
+type lastKnownLogs struct {
+	Logs    []PatientLog
+	TakenAt time.Time
+}
+
+// Keyed like queue cache (branch, patient, date), but kept for the whole day
+var lastKnown = struct {
+	sync.Mutex
+	byKey map[string]lastKnownLogs
+}{byKey: make(map[string]lastKnownLogs)}
+
+func rememberQueueLogs(key string, logs []PatientLog, takenAt time.Time) {
+	lastKnown.Lock()
+	defer lastKnown.Unlock()
+
+	lastKnown.byKey[key] = lastKnownLogs{Logs: copyLogs(logs), TakenAt: takenAt}
+}
+
+// Latest logs of a patient read today
+func LastKnownQueueLogs(branchCode, patientID string) ([]PatientLog, time.Time, bool) {
+	lastKnown.Lock()
+	defer lastKnown.Unlock()
+
+	known, exist := lastKnown.byKey[queueCacheKey(branchCode, patientID, queueDate())]
+	if !exist {
+		return nil, time.Time{}, false
+	}
+	return copyLogs(known.Logs), known.TakenAt, true
+}
+
+// Whether anything can be served while every database is down: remembered lookups or snapshot of today
+func hasLastKnownData() bool {
+	date := queueDate()
+
+	lastKnown.Lock()
+	for key := range lastKnown.byKey {
+		if strings.HasSuffix(key, "|"+date) {
+			lastKnown.Unlock()
+			return true
+		}
+	}
+	lastKnown.Unlock()
+
+	snapshots.RLock()
+	defer snapshots.RUnlock()
+	for _, snapshot := range snapshots.byBranch {
+		if snapshot.Date == date {
+			return true
+		}
+	}
+	return false
+}
+
+// Drop logs of previous days
+func forgetOutdatedQueueLogs(date string) {
+	lastKnown.Lock()
+	defer lastKnown.Unlock()
+
+	for key := range lastKnown.byKey {
+		if !strings.HasSuffix(key, "|"+date) {
+			delete(lastKnown.byKey, key)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Data pushed to live pages (server-sent events). One feed per key is shared by every client showing it,
+// and feed only polls database while it has subscribers
+type liveFeed struct {
+	key         string
+	build       func() (interface{}, error)
+	subscribers map[chan []byte]bool
+	last        []byte
+	stop        chan struct{}
+}
+
+var liveFeeds = struct {
+	sync.Mutex
+	feeds map[string]*liveFeed
+}{feeds: make(map[string]*liveFeed)}
+
+// build is only used by the first subscriber of the key, so it must produce the same data for every subscriber
+func subscribeFeed(key string, build func() (interface{}, error)) (chan []byte, func()) {
+	ch := make(chan []byte, 1)
+
+	liveFeeds.Lock()
+	feed, exist := liveFeeds.feeds[key]
+	if !exist {
+		feed = &liveFeed{
+			key:         key,
+			build:       build,
+			subscribers: make(map[chan []byte]bool),
+			stop:        make(chan struct{}),
+		}
+		liveFeeds.feeds[key] = feed
+		go feed.run()
+	}
+	feed.subscribers[ch] = true
+	if feed.last != nil {
+		ch <- feed.last
+	}
+	liveFeeds.Unlock()
+
+	unsubscribe := func() {
+		liveFeeds.Lock()
+		defer liveFeeds.Unlock()
+
+		delete(feed.subscribers, ch)
+		if len(feed.subscribers) == 0 {
+			close(feed.stop)
+			delete(liveFeeds.feeds, key)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+func (feed *liveFeed) run() {
+	ticker := time.NewTicker(AppConfig.BoardRefresh)
+	defer ticker.Stop()
+
+	for {
+		feed.refresh()
+
+		select {
+		case <-feed.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (feed *liveFeed) refresh() {
+	payload, err := feed.build()
+	if err != nil {
+		// Keep showing previous data to clients
+		ErrorLogger.Printf("feed: fail to build payload for %v. %v", feed.key, err)
+		return
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		ErrorLogger.Printf("feed: fail to marshal payload for %v. %v", feed.key, err)
+		return
+	}
+
+	liveFeeds.Lock()
+	defer liveFeeds.Unlock()
+
+	feed.last = b
+	for ch := range feed.subscribers {
+		// Slow client: replace pending (outdated) payload instead of blocking other clients
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- b
+	}
+}
+
+// Heartbeat keeps idle stream from being dropped by proxy. Write deadline of stream covers wait until next heartbeat
+var (
+	feedHeartbeat    = 30 * time.Second
+	feedWriteTimeout = 10 * time.Second
+)
+
+const connKey contextKey = "conn"
+
+// Server ConnContext. Keeps connection of request, so event stream can extend its write deadline
+func saveConn(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey, conn)
+}
+
+// Serve feed as server-sent events until client disconnects
+func StreamFeed(w http.ResponseWriter, r *http.Request, key string, build func() (interface{}, error)) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		requestLogger(r, ErrorLogger).Printf("feed: response writer doesn't support streaming")
+		httpError(w, r, "streaming tidak didukung.", http.StatusInternalServerError)
+		return
+	}
+
+	// Stream outlives server WriteTimeout. Deadline is extended on each write instead, so a client that stops reading is still dropped
+	conn, _ := r.Context().Value(connKey).(net.Conn)
+	extendDeadline := func() {
+		if conn == nil {
+			return
+		}
+		if err := conn.SetWriteDeadline(time.Now().Add(feedHeartbeat + feedWriteTimeout)); err != nil {
+			requestLogger(r, WarnLogger).Printf("feed: fail to set write deadline. %v", err)
+		}
+	}
+	extendDeadline()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch, unsubscribe := subscribeFeed(key, build)
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shutdownStarted:
+			// Server is draining, stream never becomes idle. Browser reconnects to the new instance
+			return
+		case b := <-ch:
+			extendDeadline()
+			fmt.Fprintf(w, "data: %s\n\n", b)
+			flusher.Flush()
+		case <-heartbeat.C:
+			extendDeadline()
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Event stream outlives server WriteTimeout, as long as heartbeats are written
+func TestStreamFeedWriteTimeout(t *testing.T) {
+	heartbeat, writeTimeout := feedHeartbeat, feedWriteTimeout
+	t.Cleanup(func() { feedHeartbeat, feedWriteTimeout = heartbeat, writeTimeout })
+	feedHeartbeat, feedWriteTimeout = 50*time.Millisecond, 50*time.Millisecond
+
+	// Wrapped like in Router, so deadline is set through statusRecorder
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		StreamFeed(&statusRecorder{ResponseWriter: w, status: http.StatusOK}, r, "feed-test", func() (interface{}, error) {
+			return "halo", nil
+		})
+	})
+	server := httptest.NewUnstartedServer(handler)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Config.ConnContext = saveConn
+	server.Start()
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	pings := 0
+	reader := bufio.NewReader(res.Body)
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for pings < 6 && time.Now().Before(deadline) {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream closed after %v heartbeats. %v", pings, err)
+		}
+		if strings.HasPrefix(line, ": ping") {
+			pings++
+		}
+	}
+	if pings < 6 {
+		t.Errorf("wrong heartbeat count: get %v want 6", pings)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// Templates and static files are built into binary, so a branch server only needs the binary and its config.
+// Underscore files (partial templates) are matched by pattern, as directory embedding skips them
+//
+//go:embed template/*.html static
+var embeddedFiles embed.FS
+
+// Files read and written at runtime. Set by flags, falling back to KMN_* env then default
+type FilePaths struct {
+	ConfigEnv    string
+	ConfigJSON   string
+	Notification string
+	LogFile      string // overrides LOG_FILE in config.env
+	AssetDir     string // optional directory with template/ and static/ files replacing embedded ones, e.g. branding
+}
+
+var Paths = FilePaths{
+	ConfigEnv:    "./config.env",
+	ConfigJSON:   "./config.json",
+	Notification: "./notification.json",
+}
+
+func envOr(key string, default_value string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return default_value
+}
+
+// Returns arguments after flags, i.e. command and its arguments. Flags are only parsed before command
+func (p *FilePaths) parseFlags(args []string) ([]string, error) {
+	flags := flag.NewFlagSet("queueinfo", flag.ContinueOnError)
+	flags.StringVar(&p.ConfigEnv, "config", envOr("KMN_CONFIG", p.ConfigEnv), "path of config.env (env KMN_CONFIG)")
+	flags.StringVar(&p.ConfigJSON, "config-json", envOr("KMN_CONFIG_JSON", p.ConfigJSON), "path of config.json (env KMN_CONFIG_JSON)")
+	flags.StringVar(&p.Notification, "notification", envOr("KMN_NOTIFICATION", p.Notification), "path of notification.json (env KMN_NOTIFICATION)")
+	flags.StringVar(&p.LogFile, "log-file", envOr("KMN_LOG_FILE", p.LogFile), "path of log file, overrides LOG_FILE (env KMN_LOG_FILE)")
+	flags.StringVar(&p.AssetDir, "assets", envOr("KMN_ASSET_DIR", p.AssetDir), "directory overriding embedded template/ and static/ files (env KMN_ASSET_DIR)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: queueinfo [flags] [command]\n\ncommands:\n%v\nflags:\n", commandUsage)
+		flags.PrintDefaults()
+	}
+	err := flags.Parse(args)
+	return flags.Args(), err
+}
+
+// Embedded files, with files of AssetDir taking precedence
+func appFiles() (fs.FS, error) {
+	if Paths.AssetDir == "" {
+		return embeddedFiles, nil
+	}
+	if info, err := os.Stat(Paths.AssetDir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%v is not a directory", Paths.AssetDir)
+	}
+
+	InfoLogger.Printf("files in %v override embedded templates and static files", Paths.AssetDir)
+	return overlayFS{override: os.DirFS(Paths.AssetDir), base: embeddedFiles}, nil
+}
+
+// File system where override only needs to contain the changed files
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	f, err := o.override.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.base.Open(name)
+}
+
+// Entries of both, so files only added in override are also listed (e.g. when fingerprinting static files)
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	overrideEntries, overrideErr := fs.ReadDir(o.override, name)
+	baseEntries, baseErr := fs.ReadDir(o.base, name)
+	if overrideErr != nil && baseErr != nil {
+		return nil, baseErr
+	}
+
+	merged := map[string]fs.DirEntry{}
+	for _, entry := range baseEntries {
+		merged[entry.Name()] = entry
+	}
+	for _, entry := range overrideEntries {
+		merged[entry.Name()] = entry
+	}
+
+	entries := make([]fs.DirEntry, 0, len(merged))
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
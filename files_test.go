@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlayFS(t *testing.T) {
+	base := fstest.MapFS{
+		"static/assets/logo-lg.png": {Data: []byte("kmn logo")},
+		"static/css/style.css":      {Data: []byte("body {}")},
+		"template/_header.html":     {Data: []byte("header")},
+	}
+	override := fstest.MapFS{
+		"static/assets/logo-lg.png":   {Data: []byte("branch logo")},
+		"static/assets/branch-bg.png": {Data: []byte("background")},
+	}
+	files := overlayFS{override: override, base: base}
+
+	tests := map[string]string{
+		"static/assets/logo-lg.png":   "branch logo",
+		"static/assets/branch-bg.png": "background",
+		"static/css/style.css":        "body {}",
+		"template/_header.html":       "header",
+	}
+	for name, want := range tests {
+		if get, err := fs.ReadFile(files, name); err != nil || string(get) != want {
+			t.Errorf("case %v: wrong content: get %q want %q. %v", name, get, want, err)
+		}
+	}
+	if _, err := files.Open("static/missing.css"); err == nil {
+		t.Errorf("missing file is opened")
+	}
+
+	// Static files are walked for fingerprinting
+	staticFiles, _ := fs.Sub(files, "static")
+	var walked []string
+	fs.WalkDir(staticFiles, ".", func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			walked = append(walked, name)
+		}
+		return err
+	})
+	want := []string{"assets/branch-bg.png", "assets/logo-lg.png", "css/style.css"}
+	if len(walked) != len(want) {
+		t.Fatalf("wrong walked files: get %v want %v", walked, want)
+	}
+	for i := range want {
+		if walked[i] != want[i] {
+			t.Errorf("wrong walked files: get %v want %v", walked, want)
+		}
+	}
+}
+
+func TestEmbeddedFiles(t *testing.T) {
+	// Partial templates start with underscore, which directory embedding would skip
+	for _, name := range []string{"template/_header.html", "template/_footer.html", "template/queue.html", "static/css/bootstrap.min.css"} {
+		if _, err := fs.Stat(embeddedFiles, name); err != nil {
+			t.Errorf("case %v: not embedded. %v", name, err)
+		}
+	}
+}
+
+func TestFilePathsFlags(t *testing.T) {
+	os.Setenv("KMN_NOTIFICATION", "/var/lib/queueinfo/notification.json")
+	os.Setenv("KMN_CONFIG", "/etc/queueinfo/config.env")
+	defer os.Unsetenv("KMN_NOTIFICATION")
+	defer os.Unsetenv("KMN_CONFIG")
+
+	paths := FilePaths{ConfigEnv: "./config.env", ConfigJSON: "./config.json", Notification: "./notification.json"}
+	args, err := paths.parseFlags([]string{"-config", "/opt/config.env", "-log-file", "/var/log/queueinfo.log", "lookup", "-branch", "kmn01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 3 || args[0] != "lookup" {
+		t.Errorf("wrong command arguments: %v", args)
+	}
+
+	want := FilePaths{
+		ConfigEnv:    "/opt/config.env", // flag takes precedence over env
+		ConfigJSON:   "./config.json",
+		Notification: "/var/lib/queueinfo/notification.json",
+		LogFile:      "/var/log/queueinfo.log",
+	}
+	if paths != want {
+		t.Errorf("wrong paths: get %+v want %+v", paths, want)
+	}
+
+	if _, err := paths.parseFlags([]string{"-unknown"}); err == nil {
+		t.Errorf("unknown flag is accepted")
+	}
+
+	paths = FilePaths{ConfigJSON: "./config.json"}
+	if _, err := paths.parseFlags([]string{"-config-json", "/etc/queueinfo/config.json"}); err != nil || paths.ConfigJSON != "/etc/queueinfo/config.json" {
+		t.Errorf("wrong config.json path: %v. %v", paths.ConfigJSON, err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Build info, set with -ldflags "-X main.Version=... -X main.Commit=... -X main.BuildTime=..."
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+var startedAt = time.Now()
+
+// Set once shutdown begins, so load balancer stops sending new requests before server is closed
+var shuttingDown int32
+
+// Closed once shutdown begins, so long-lived streams can end
+var (
+	shutdownStarted = make(chan struct{})
+	shutdownOnce    sync.Once
+)
+
+func beginShutdown() {
+	atomic.StoreInt32(&shuttingDown, 1)
+	shutdownOnce.Do(func() { close(shutdownStarted) })
+}
+
+func isShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// Checksum of config files, to tell which config a running instance has loaded
+func configChecksum(paths ...string) (string, error) {
+	hash := sha256.New()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type ReadinessCheck struct {
+	OK       bool   `json:"ok"`
+	Degraded bool   `json:"degraded,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func readinessCheck(err error) ReadinessCheck {
+	if err != nil {
+		return ReadinessCheck{OK: false, Error: err.Error()}
+	}
+	return ReadinessCheck{OK: true}
+}
+
+func checkTemplates() error {
+	templates := map[string]*template.Template{
+		"home": TemplateHome, "display": TemplateDisplay, "error": TemplateError, "board": TemplateBoard,
+		"login": TemplateLogin, "edit-notification": TemplateEditNotification, "dashboard": TemplateDashboard, "report": TemplateReport,
+	}
+	for name, t := range templates {
+		if t == nil {
+			return fmt.Errorf("template %v is not loaded", name)
+		}
+	}
+	return nil
+}
+
+// Notification file must be readable JSON. Missing or empty file is fine, it's written on first save
+func checkNotificationStore() error {
+	f, err := os.Open(Paths.Notification)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var notifications map[string]interface{}
+	if err := json.NewDecoder(f).Decode(&notifications); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func checkConfig() error {
+	if len(AppConfig.Branches) == 0 {
+		return errors.New("no branch defined in config")
+	}
+	return AppConfig.Schema.validate()
+}
+
+// Database check fails only when no branch database is reachable, as other branches can still be served
+func checkDatabaseReadiness() (map[string]DatabaseHealth, error) {
+	health := BranchDatabaseHealth()
+	for _, h := range health {
+		if h.Up {
+			return health, nil
+		}
+	}
+	return health, errors.New("no branch database is reachable")
+}
+
+// Without any database, last known data is still served with warning. Failing readiness then would
+// make load balancer pull every instance, so database is only reported as degraded
+func databaseReadiness() (map[string]DatabaseHealth, ReadinessCheck) {
+	health, err := checkDatabaseReadiness()
+	if err != nil && hasLastKnownData() {
+		return health, ReadinessCheck{OK: true, Degraded: true, Error: err.Error()}
+	}
+	return health, readinessCheck(err)
+}
+
+//========================================================================//
+// ** Handlers **//
+
+func writeHealthJSON(w http.ResponseWriter, status int, body interface{}) {
+	b, err := json.MarshalIndent(body, "", "  ")
+	if err != nil {
+		ErrorLogger.Printf("health: fail to marshal response. %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// Process is alive and serving
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "ok",
+		"uptime": time.Since(startedAt).Round(time.Second).String(),
+	})
+}
+
+// App can serve requests. Fails during shutdown
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	databases, dbCheck := databaseReadiness()
+	checks := map[string]ReadinessCheck{
+		"database":     dbCheck,
+		"templates":    readinessCheck(checkTemplates()),
+		"notification": readinessCheck(checkNotificationStore()),
+		"config":       readinessCheck(checkConfig()),
+	}
+
+	ready := !isShuttingDown()
+	for _, check := range checks {
+		ready = ready && check.OK
+	}
+
+	status, code := "ready", http.StatusOK
+	if isShuttingDown() {
+		status, code = "shutting down", http.StatusServiceUnavailable
+	} else if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	} else if dbCheck.Degraded {
+		status = "degraded"
+	}
+
+	writeHealthJSON(w, code, map[string]interface{}{
+		"status":    status,
+		"checks":    checks,
+		"databases": databases,
+	})
+}
+
+func VersionHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthJSON(w, http.StatusOK, map[string]interface{}{
+		"version":         Version,
+		"commit":          Commit,
+		"build-time":      BuildTime,
+		"go-version":      runtime.Version(),
+		"config-checksum": AppConfig.Checksum,
+		"started-at":      startedAt.Format(time.RFC3339),
+	})
+}
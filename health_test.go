@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	// Shutdown would otherwise end event streams of later tests
+	t.Cleanup(func() {
+		atomic.StoreInt32(&shuttingDown, 0)
+		shutdownStarted, shutdownOnce = make(chan struct{}), sync.Once{}
+	})
+
+	type Test struct {
+		name         string
+		handler      http.HandlerFunc
+		shuttingDown bool
+		code         int
+		status       string
+	}
+
+	tests := []Test{
+		{name: "healthz", handler: HealthzHandler, code: http.StatusOK, status: "ok"},
+		{name: "healthz during shutdown", handler: HealthzHandler, shuttingDown: true, code: http.StatusOK, status: "ok"},
+		{name: "readyz during shutdown", handler: ReadyzHandler, shuttingDown: true, code: http.StatusServiceUnavailable, status: "shutting down"},
+		{name: "version", handler: VersionHandler, code: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		atomic.StoreInt32(&shuttingDown, 0)
+		if tt.shuttingDown {
+			beginShutdown()
+		}
+
+		w := httptest.NewRecorder()
+		tt.handler(w, httptest.NewRequest("GET", "/", nil))
+
+		if w.Code != tt.code {
+			t.Errorf("case %v: wrong status code: get %v want %v", tt.name, w.Code, tt.code)
+		}
+
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Errorf("case %v: response is not JSON. %v", tt.name, err)
+			continue
+		}
+		if tt.status != "" && body["status"] != tt.status {
+			t.Errorf("case %v: wrong status: get %v want %v", tt.name, body["status"], tt.status)
+		}
+	}
+}
+
+func TestDatabaseReadinessDegraded(t *testing.T) {
+	// Start without data left by other tests
+	lastKnown.Lock()
+	known := lastKnown.byKey
+	lastKnown.byKey = make(map[string]lastKnownLogs)
+	lastKnown.Unlock()
+	snapshots.Lock()
+	branches := snapshots.byBranch
+	snapshots.byBranch = make(map[string]*branchSnapshot)
+	snapshots.Unlock()
+	t.Cleanup(func() {
+		lastKnown.Lock()
+		lastKnown.byKey = known
+		lastKnown.Unlock()
+		snapshots.Lock()
+		snapshots.byBranch = branches
+		snapshots.Unlock()
+	})
+
+	// No database is opened in test, so none is reachable
+	if _, check := databaseReadiness(); check.OK {
+		t.Errorf("database ready without database nor last known data: %+v", check)
+	}
+
+	rememberQueueLogs(queueCacheKey("kbj", "A001", queueDate()), []PatientLog{{Group: "PREOP", Status: "I"}}, time.Now())
+
+	if _, check := databaseReadiness(); !check.OK || !check.Degraded || check.Error == "" {
+		t.Errorf("last known data must keep instance ready as degraded: %+v", check)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var logLevelNames = map[LogLevel]string{LevelDebug: "debug", LevelInfo: "info", LevelWarn: "warn", LevelError: "error"}
+
+func parseLogLevel(name string) (LogLevel, bool) {
+	for level, n := range logLevelNames {
+		if strings.EqualFold(name, n) {
+			return level, true
+		}
+	}
+	return LevelInfo, false
+}
+
+// Extra key-value pairs of a log entry
+type Fields map[string]interface{}
+
+// Where and how entries are written. Shared by every Logger
+type LogSettings struct {
+	Format string // json or logfmt
+	Level  LogLevel
+	Output string // stdout, stderr or file
+	File   string
+
+	// Rotation of log file, whichever comes first. Zero disables
+	MaxSize        int64
+	RotateInterval time.Duration
+	MaxBackups     int
+
+	// Key of patient ID hash, so the same patient can be followed across entries without exposing the ID
+	HashKey []byte
+}
+
+func defaultLogSettings() LogSettings {
+	return LogSettings{Format: "json", Level: LevelInfo, Output: "file", File: "logs.txt", HashKey: []byte("kmn-antrian")}
+}
+
+var logOutput = struct {
+	sync.Mutex
+	settings LogSettings
+	writer   io.Writer
+	file     *rotatingFile
+}{settings: defaultLogSettings(), writer: os.Stderr}
+
+// Logger of one level. Printf/Fatalf keep signature of log.Logger, so existing call sites write structured entries
+type Logger struct {
+	level  LogLevel
+	fields Fields
+}
+
+func NewLogger(level LogLevel) *Logger {
+	return &Logger{level: level}
+}
+
+// Copy of logger with additional fields
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{level: l.level, fields: merged}
+}
+
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.output(2, fmt.Sprintf(format, v...))
+}
+
+func (l *Logger) Print(v ...interface{}) {
+	l.output(2, fmt.Sprint(v...))
+}
+
+func (l *Logger) Println(v ...interface{}) {
+	l.output(2, fmt.Sprintln(v...))
+}
+
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	l.output(2, fmt.Sprintf(format, v...))
+	CloseLogger()
+	os.Exit(1)
+}
+
+func (l *Logger) Fatal(v ...interface{}) {
+	l.output(2, fmt.Sprint(v...))
+	CloseLogger()
+	os.Exit(1)
+}
+
+func (l *Logger) Fatalln(v ...interface{}) {
+	l.output(2, fmt.Sprintln(v...))
+	CloseLogger()
+	os.Exit(1)
+}
+
+func (l *Logger) output(depth int, msg string) {
+	logOutput.Lock()
+	defer logOutput.Unlock()
+
+	settings := logOutput.settings
+	if l.level < settings.Level {
+		return
+	}
+
+	caller := "???"
+	if _, file, line, ok := runtime.Caller(depth); ok {
+		caller = filepath.Base(file) + ":" + strconv.Itoa(line)
+	}
+
+	entry := Fields{}
+	for k, v := range l.fields {
+		entry[k] = redactField(k, v, settings.HashKey)
+	}
+	entry["time"] = time.Now().Format(time.RFC3339)
+	entry["level"] = logLevelNames[l.level]
+	entry["caller"] = caller
+	entry["msg"] = redactMessage(strings.TrimSuffix(msg, "\n"))
+
+	var line []byte
+	if settings.Format == "logfmt" {
+		line = formatLogfmt(entry)
+	} else {
+		line, _ = json.Marshal(entry)
+	}
+	logOutput.writer.Write(append(line, '\n'))
+}
+
+// Standard logger writing through l, for libraries expecting *log.Logger (e.g. http.Server)
+func (l *Logger) StdLogger() *log.Logger {
+	return log.New(stdLogWriter{l}, "", 0)
+}
+
+type stdLogWriter struct {
+	logger *Logger
+}
+
+func (w stdLogWriter) Write(p []byte) (int, error) {
+	w.logger.output(4, string(p))
+	return len(p), nil
+}
+
+//========================================================================//
+// ** Redaction **//
+
+var sensitiveKeys = []string{"password", "passwd", "pswd", "secret", "token", "key"}
+
+// Key/value in free text, e.g. "Password: abc" or "password=abc"
+var sensitiveMessageExp = regexp.MustCompile(`(?i)\b(password|passwd|pswd|secret|token)(\s*[:=]\s*)\S+`)
+
+func redactField(key string, value interface{}, hashKey []byte) interface{} {
+	lower := strings.ToLower(key)
+	if lower == "patient" {
+		return HashPatientID(fmt.Sprint(value), hashKey)
+	}
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(lower, sensitive) {
+			return "[REDACTED]"
+		}
+	}
+	return value
+}
+
+func redactMessage(msg string) string {
+	return sensitiveMessageExp.ReplaceAllString(msg, "${1}${2}[REDACTED]")
+}
+
+// Short keyed hash of queue number. Plain hash could be reversed, as queue numbers are few
+func HashPatientID(id string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+func formatLogfmt(entry Fields) []byte {
+	keys := make([]string, 0, len(entry))
+	for k := range entry {
+		if k != "time" && k != "level" && k != "msg" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	keys = append([]string{"time", "level", "msg"}, keys...)
+
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		v := fmt.Sprint(entry[k])
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(k + "=" + v)
+	}
+	return []byte(b.String())
+}
+
+//========================================================================//
+// ** Output **//
+
+// Apply settings to every logger. Previous log file is closed
+func InitLogger(settings LogSettings) error {
+	var writer io.Writer = os.Stdout
+	var file *rotatingFile
+	if settings.Output == "stderr" {
+		writer = os.Stderr
+	} else if settings.Output != "stdout" {
+		var err error
+		file, err = openRotatingFile(settings.File, settings.MaxSize, settings.RotateInterval, settings.MaxBackups)
+		if err != nil {
+			return err
+		}
+		writer = file
+	}
+
+	logOutput.Lock()
+	defer logOutput.Unlock()
+
+	if logOutput.file != nil {
+		logOutput.file.Close()
+	}
+	logOutput.settings = settings
+	logOutput.writer = writer
+	logOutput.file = file
+	return nil
+}
+
+// Flush and close log file. Entries written afterwards go to stderr
+func CloseLogger() {
+	logOutput.Lock()
+	defer logOutput.Unlock()
+
+	if logOutput.file != nil {
+		logOutput.file.Close()
+		logOutput.file = nil
+	}
+	logOutput.writer = os.Stderr
+}
+
+// Log file rotated by size and age. Rotated file is renamed with timestamp suffix, oldest is removed beyond MaxBackups
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func openRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxSize: maxSize, interval: interval, maxBackups: maxBackups}
+	return f, f.open()
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	// Existing file keeps its age across restart, otherwise frequent restarts would never rotate by interval
+	f.openedAt = time.Now()
+	if f.size > 0 {
+		f.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// Called with logOutput locked
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	tooBig := f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize
+	tooOld := f.interval > 0 && time.Since(f.openedAt) >= f.interval
+	if tooBig || tooOld {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "fail to rotate log file %v. %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	backup := f.backupName(time.Now())
+	if err := os.Rename(f.path, backup); err != nil {
+		f.open()
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+
+	if f.maxBackups > 0 {
+		backups, _ := filepath.Glob(f.path + ".*")
+		sort.Strings(backups) // timestamp suffix sorts by age
+		for len(backups) > f.maxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+// Nanosecond suffix keeps names sortable by age. Sequence is added in the rare case it is already taken
+func (f *rotatingFile) backupName(now time.Time) string {
+	name := f.path + "." + now.Format("20060102-150405.000000000")
+	backup := name
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(backup); os.IsNotExist(err) {
+			return backup
+		}
+		backup = fmt.Sprintf("%v-%v", name, i)
+	}
+}
+
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	f.file.Sync()
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerRedaction(t *testing.T) {
+	dir := t.TempDir()
+
+	settings := defaultLogSettings()
+	settings.File = filepath.Join(dir, "logs.txt")
+	settings.Level = LevelWarn
+	if err := InitLogger(settings); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := InitLogger(defaultLogSettings()); err != nil {
+			log.Fatal("Fail to initialize logger!")
+		}
+	}()
+
+	InfoLogger.Printf("below level, not written")
+	WarnLogger.With(Fields{"patient": "A001", "password": "rahasia", "branch": "kbj"}).Printf("login failed. Password: rahasia")
+	CloseLogger()
+
+	b, err := os.ReadFile(settings.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("wrong number of entries: get %v want 1. log: %s", len(lines), b)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("entry is not JSON. %v", err)
+	}
+	if strings.Contains(lines[0], "rahasia") || strings.Contains(lines[0], "A001") {
+		t.Errorf("sensitive value written to log: %v", lines[0])
+	}
+	if entry["patient"] != HashPatientID("A001", settings.HashKey) || entry["branch"] != "kbj" || entry["level"] != "warn" {
+		t.Errorf("wrong fields: %v", entry)
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	get := string(formatLogfmt(Fields{"time": "t", "level": "info", "msg": "no room", "branch": "kbj", "route": ""}))
+	want := `time=t level=info msg="no room" branch=kbj route=""`
+	if get != want {
+		t.Errorf("wrong logfmt:\nget  %v\nwant %v", get, want)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "logs.txt")
+	f, err := openRotatingFile(path, 10, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("0123456789"))
+	f.Write([]byte("abc")) // exceeds size: rotated
+
+	if b, _ := os.ReadFile(path); string(b) != "abc" {
+		t.Errorf("wrong content after rotation: %q", b)
+	}
+	if backups, _ := filepath.Glob(path + ".*"); len(backups) != 1 {
+		t.Errorf("wrong backups: %v", backups)
+	}
+}
+
+func TestRotatingFileBackupNames(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "logs.txt")
+	f, err := openRotatingFile(path, 1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Every write rotates, all within the same second
+	for i := 0; i < 5; i++ {
+		f.Write([]byte("x"))
+	}
+	if backups, _ := filepath.Glob(path + ".*"); len(backups) != 4 {
+		t.Errorf("wrong number of backups: get %v want 4. %v", len(backups), backups)
+	}
+
+	now := time.Now()
+	first := f.backupName(now)
+	os.WriteFile(first, nil, 0644)
+	if second := f.backupName(now); second == first || second < first {
+		t.Errorf("taken backup name reused or sorted before: %v after %v", second, first)
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "logs.txt")
+	os.WriteFile(path, []byte("yesterday\n"), 0644)
+	old := time.Now().Add(-25 * time.Hour)
+	os.Chtimes(path, old, old)
+
+	// Restart with existing file: its age counts, not the time it was reopened
+	f, err := openRotatingFile(path, 0, 24*time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("today\n"))
+
+	if b, _ := os.ReadFile(path); string(b) != "today\n" {
+		t.Errorf("old file not rotated: %q", b)
+	}
+}
@@ -1,33 +1,75 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"time"
 )
 
 var (
 	AppConfig Config
 
-	// Tools
-	InfoLogger  *log.Logger
-	ErrorLogger *log.Logger
+	// Tools. Output and minimum level are set by InitLogger
+	DebugLogger = NewLogger(LevelDebug)
+	InfoLogger  = NewLogger(LevelInfo)
+	WarnLogger  = NewLogger(LevelWarn)
+	ErrorLogger = NewLogger(LevelError)
 )
 
 func main() {
-	// Initialize logger
-	file, err := os.OpenFile("logs.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	// Paths of config, notification and log files
+	args, err := Paths.parseFlags(os.Args[1:])
 	if err != nil {
+		os.Exit(2)
+	}
+
+	// Without command, server is run as before
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	if command == "serve" {
+		serve()
+		return
+	}
+
+	if err := runCommand(command, args, os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", command, err)
+		os.Exit(1)
+	}
+}
+
+func serve() {
+	// Initialize logger with default settings, until config is read
+	settings := defaultLogSettings()
+	if Paths.LogFile != "" {
+		settings.File = Paths.LogFile
+	}
+	if err := InitLogger(settings); err != nil {
 		log.Fatal("Fail to initialize logger!")
 	}
-	InfoLogger = log.New(file, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(file, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
 
 	// Read config (static values)
 	AppConfig.readConfig()
+	if err := InitLogger(AppConfig.Log); err != nil {
+		log.Fatalf("Fail to initialize logger! %v", err)
+	}
 
 	// Initialize handler, database, and several other tools
 	Initialize()
 
-	// Starting the app
-	Run(AppConfig.Port)
+	// Starting the app, returns on shutdown
+	if err := Run(AppConfig.Port); err != nil {
+		ErrorLogger.Printf("server stopped with error. %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ShutdownTracing(ctx)
+	cancel()
+
+	CloseDatabases()
+	InfoLogger.Printf("app stopped")
+	CloseLogger()
 }
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"log"
+	"os"
+	"testing"
+)
+
+// Logger and config shared by all tests. RoomMap must be populated as reference, that needs logger too.
+// Tests changing AppConfig restore it with t.Cleanup
+func TestMain(m *testing.M) {
+	if err := InitLogger(defaultLogSettings()); err != nil {
+		log.Fatal("Fail to initialize logger!")
+	}
+	AppConfig.readConfig()
+	os.Exit(m.Run())
+}
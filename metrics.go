@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels only use route templates, validated branch/process codes and fixed values, so cardinality stays bounded.
+// Queue numbers and login usernames are never used as label
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "queueinfo_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "queueinfo_http_request_duration_seconds",
+		Help:    "HTTP request latency by route and method. Event streams are excluded.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "queueinfo_query_duration_seconds",
+		Help:    "HIS database query latency by branch and query.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"branch", "query"})
+
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "queueinfo_query_errors_total",
+		Help: "Failed HIS database queries by branch and query. No-data result isn't an error.",
+	}, []string{"branch", "query"})
+
+	queryRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "queueinfo_query_rejected_total",
+		Help: "HIS database queries skipped because circuit breaker is open.",
+	}, []string{"branch", "query"})
+
+	noDataResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "queueinfo_no_data_total",
+		Help: "Patient lookups without room data by branch and process.",
+	}, []string{"branch", "process"})
+
+	loginAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "queueinfo_login_attempts_total",
+		Help: "kmn-internal login attempts by branch (unknown for unregistered user) and result.",
+	}, []string{"branch", "result"})
+
+	notificationSaves = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "queueinfo_notification_saves_total",
+		Help: "Saved notification edits by branch.",
+	}, []string{"branch"})
+
+	cspViolations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "queueinfo_csp_violations_total",
+		Help: "Content Security Policy violations reported by browsers, by directive.",
+	}, []string{"directive"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, queryDuration, queryErrors, queryRejected,
+		noDataResults, loginAttempts, notificationSaves, cspViolations)
+
+	prometheus.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "queueinfo_cache_hits_total",
+			Help: "Queue lookups served from cache.",
+		}, func() float64 { return float64(queueLookupCache.stats().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "queueinfo_cache_misses_total",
+			Help: "Queue lookups not found in cache.",
+		}, func() float64 { return float64(queueLookupCache.stats().Misses) }),
+	)
+}
+
+// Keeps status code for metrics. Flush is passed through for event streams
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Middleware of matched routes. Unmatched requests are counted by NotFoundHandler route "not-found"
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "not-found"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		if !strings.HasSuffix(route, "/events") {
+			httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+		}
+	})
+}
+
+// Branch code is only used as label once validated
+func loginBranchLabel(username string) string {
+	if AppConfig.validateBranch(username) {
+		return username
+	}
+	return "unknown"
+}
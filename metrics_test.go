@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/board/{branch}/{process}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	router.NotFoundHandler = metricsMiddleware(http.NotFoundHandler())
+	router.Use(metricsMiddleware)
+
+	type Test struct {
+		path  string
+		route string
+		code  string
+	}
+
+	tests := []Test{
+		// queue number and branch in path must not become label
+		{path: "/board/kbj/opr", route: "/board/{branch}/{process}", code: "400"},
+		{path: "/unknown/A001", route: "not-found", code: "404"},
+	}
+
+	for _, tt := range tests {
+		before := testutil.ToFloat64(httpRequests.WithLabelValues(tt.route, "GET", tt.code))
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+
+		if after := testutil.ToFloat64(httpRequests.WithLabelValues(tt.route, "GET", tt.code)); after != before+1 {
+			t.Errorf("case %v: request not counted with route %v code %v", tt.path, tt.route, tt.code)
+		}
+	}
+
+	// Event streams need flusher
+	var _ http.Flusher = &statusRecorder{}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
@@ -14,23 +15,47 @@ type PatientLog struct {
 	Status string
 }
 
-// Parse DateTime column according to our need (time only)
+// Parse DateTime column according to our need (time only). Layout follows schema config
 type RawTime []byte
 
 func (t RawTime) Time() (time.Time, error) {
-	return time.Parse("15:04:05", string(t))
+	return time.Parse(AppConfig.Schema.TimeFormat, string(t))
 }
 
-func GetQueueLogs(db *sql.DB, branchID, patientID string) ([]PatientLog, error) {
-	var date string
+// Log only has time, so place it on the given day
+func onDate(t time.Time, day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), t.Second(), 0, day.Location())
+}
+
+// Date of queue to be read from database
+func queueDate() string {
 	if AppConfig.IsDev {
-		date = "2021-08-24"
+		dev, _ := time.Parse("2006-01-02", "2021-08-24")
+		return dev.Format(AppConfig.Schema.DateFormat)
 	} else {
-		date = time.Now().Format("2006-01-02") //YYYY-MM-DD
+		return time.Now().Format(AppConfig.Schema.DateFormat)
 	}
+}
+
+// Lookup queries are limited by DB_QUERY_TIMEOUT, so hanging database doesn't hold the request
+func queryContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), AppConfig.DatabaseQueryTimeout)
+}
+
+// History queries read up to months of logs, so they have own DB_HISTORY_TIMEOUT
+func historyQueryContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), AppConfig.DatabaseHistoryTimeout)
+}
+
+func GetQueueLogs(db *sql.DB, branchID, patientID string) ([]PatientLog, error) {
+	date := queueDate()
+
+	ctx, cancel := queryContext()
+	defer cancel()
 
 	// Read data from database
-	rows, err := db.Query("SELECT DISTINCT kelompok, ruang, jam, status FROM antri WHERE (lokasi=? AND nomor=? AND tanggal=? AND status IN ('I','O')) ORDER BY jam", branchID, patientID, date)
+	schema := AppConfig.Schema
+	rows, err := db.QueryContext(ctx, schema.queueLogsQuery(), branchID, patientID, date, schema.StatusIn, schema.StatusOut)
 	if err != nil {
 		return nil, err
 	}
@@ -51,6 +76,7 @@ func GetQueueLogs(db *sql.DB, branchID, patientID string) ([]PatientLog, error)
 		if log.Group == "" {
 			continue
 		}
+		log.Status, _ = schema.normalizeStatus(log.Status)
 
 		log.Time, err = time.Time()
 		if err != nil {
@@ -60,6 +86,142 @@ func GetQueueLogs(db *sql.DB, branchID, patientID string) ([]PatientLog, error)
 		logs = append(logs, log)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(logs) == 0 {
+		return nil, sql.ErrNoRows
+	} else {
+		return logs, nil
+	}
+}
+
+// Read today's logs of all patients in a branch, grouped by queue number
+func GetBranchQueueLogs(db *sql.DB, branchID string) (map[string][]PatientLog, error) {
+	schema := AppConfig.Schema
+	return getBranchQueueLogs(db, schema.branchLogsQuery(), branchID, queueDate(), schema.StatusIn, schema.StatusOut)
+}
+
+// Read today's logs of a branch with time at or after since. Rows at exactly since may be returned again
+func GetBranchQueueLogsSince(db *sql.DB, branchID string, since time.Time) (map[string][]PatientLog, error) {
+	schema := AppConfig.Schema
+	return getBranchQueueLogs(db, schema.branchLogsSinceQuery(), branchID, queueDate(), schema.StatusIn, schema.StatusOut, since.Format(schema.TimeFormat))
+}
+
+func getBranchQueueLogs(db *sql.DB, query string, args ...interface{}) (map[string][]PatientLog, error) {
+	ctx, cancel := queryContext()
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	schema := AppConfig.Schema
+	patients := make(map[string][]PatientLog)
+	var log PatientLog
+
+	for rows.Next() {
+		var patientID string
+		var time RawTime
+		err := rows.Scan(&patientID, &log.Group, &log.Room, &time, &log.Status)
+		if err != nil {
+			return nil, err
+		}
+
+		if log.Group == "" {
+			continue
+		}
+		log.Status, _ = schema.normalizeStatus(log.Status)
+
+		log.Time, err = time.Time()
+		if err != nil {
+			return nil, err
+		}
+
+		patients[patientID] = append(patients[patientID], log)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return patients, nil
+}
+
+// Store raw database data of several patients and days (used for statistics)
+type HistoryLog struct {
+	PatientLog
+	PatientID string
+	Date      string
+}
+
+func GetHistoryLogs(db *sql.DB, branchID string, groups []string, from, to time.Time) ([]HistoryLog, error) {
+	if len(groups) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	// Group codes are passed as parameters, never formatted into query
+	// Note: date range comparison assumes date column is sortable in configured format (e.g. DATE column)
+	schema := AppConfig.Schema
+	args := []interface{}{branchID, from.Format(schema.DateFormat), to.Format(schema.DateFormat), schema.StatusIn, schema.StatusOut}
+	var codes, patterns []interface{}
+	for _, group := range groups {
+		if isGroupPattern(group) {
+			patterns = append(patterns, likePattern(group))
+		} else {
+			codes = append(codes, group)
+		}
+	}
+	args = append(append(args, codes...), patterns...)
+
+	ctx, cancel := historyQueryContext()
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, schema.historyLogsQuery(len(codes), len(patterns)), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var logs []HistoryLog
+	var log HistoryLog
+
+	for rows.Next() {
+		var rawTime RawTime
+		var date string
+		err := rows.Scan(&log.PatientID, &date, &log.Group, &log.Room, &rawTime, &log.Status)
+		if err != nil {
+			return nil, err
+		}
+
+		if log.Group == "" {
+			continue
+		}
+		log.Status, _ = schema.normalizeStatus(log.Status)
+
+		// Standardize date (YYYY-MM-DD) regardless of database format
+		log.Date = date
+		if d, err := time.Parse(schema.DateFormat, date); err == nil {
+			log.Date = d.Format("2006-01-02")
+		}
+
+		log.Time, err = rawTime.Time()
+		if err != nil {
+			return nil, err
+		}
+
+		logs = append(logs, log)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	if len(logs) == 0 {
 		return nil, sql.ErrNoRows
 	} else {
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Longest date range allowed for one report, to protect HIS database
+const maxReportDays = 92
+
+// Aggregate of one room in one day
+type ReportRow struct {
+	Date        string
+	Process     string
+	Room        string
+	Served      int     // patients with IN record
+	MedianDwell float64 // minutes, from first IN to first OUT
+	P90Dwell    float64
+	PeakHour    int // hour with most IN record, -1 if none
+}
+
+const (
+	ReportRunning = "proses"
+	ReportDone    = "selesai"
+	ReportFailed  = "gagal"
+)
+
+type Report struct {
+	ID         string
+	Branch     string
+	From       string
+	To         string
+	Status     string
+	Error      string
+	Rows       []ReportRow
+	CreatedAt  time.Time
+	FinishedAt time.Time
+}
+
+// Computed reports, keyed by branch and date range. Expired report is recomputed on next request
+var reports = struct {
+	sync.Mutex
+	byID map[string]*Report
+}{byID: make(map[string]*Report)}
+
+// Only one report is computed at a time
+var reportWorker = make(chan struct{}, 1)
+
+func reportID(branchCode, from, to string) string {
+	return branchCode + "_" + strings.ReplaceAll(from, "-", "") + "_" + strings.ReplaceAll(to, "-", "")
+}
+
+func getReport(id string) (Report, bool) {
+	reports.Lock()
+	defer reports.Unlock()
+
+	report, exist := reports.byID[id]
+	if !exist {
+		return Report{}, false
+	}
+	return *report, true
+}
+
+// Start computing report in background, unless the same report is running or still cached
+func RequestReport(branchCode string, from, to time.Time) string {
+	id := reportID(branchCode, from.Format("2006-01-02"), to.Format("2006-01-02"))
+
+	reports.Lock()
+	defer reports.Unlock()
+
+	if report, exist := reports.byID[id]; exist {
+		if report.Status == ReportRunning || time.Since(report.FinishedAt) < AppConfig.ReportCacheTTL {
+			return id
+		}
+	}
+
+	report := &Report{
+		ID:        id,
+		Branch:    branchCode,
+		From:      from.Format("2006-01-02"),
+		To:        to.Format("2006-01-02"),
+		Status:    ReportRunning,
+		CreatedAt: time.Now(),
+	}
+	reports.byID[id] = report
+
+	go func() {
+		reportWorker <- struct{}{}
+		rows, err := computeReport(branchCode, from, to)
+		<-reportWorker
+
+		reports.Lock()
+		defer reports.Unlock()
+
+		report.FinishedAt = time.Now()
+		if err != nil {
+			ErrorLogger.Printf("report: fail to compute %v. %v", id, err)
+			report.Status = ReportFailed
+			report.Error = "data gagal diambil. silahkan coba beberapa saat lagi."
+			return
+		}
+		report.Status = ReportDone
+		report.Rows = rows
+		InfoLogger.Printf("report: %v computed with %v row(s)", id, len(rows))
+	}()
+
+	return id
+}
+
+// Reports of a branch, latest first. Expired reports are removed
+func listReports(branchCode string) []Report {
+	reports.Lock()
+	defer reports.Unlock()
+
+	var list []Report
+	for id, report := range reports.byID {
+		if report.Status != ReportRunning && time.Since(report.FinishedAt) >= AppConfig.ReportCacheTTL {
+			delete(reports.byID, id)
+			continue
+		}
+		if report.Branch == branchCode {
+			list = append(list, *report)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+func computeReport(branchCode string, from, to time.Time) ([]ReportRow, error) {
+	_, branchID := AppConfig.getBranchInfo(branchCode)
+
+	var groups []string
+	for _, process := range ProcessLibArr {
+		groups = append(groups, AppConfig.BranchRoomSet(branchCode, process.Code).GroupCodes()...)
+	}
+
+	var logs []HistoryLog
+	err := queryBranch(context.Background(), branchCode, "report", func(db *sql.DB) error {
+		var err error
+		logs, err = GetHistoryLogs(db, branchID, groups, from, to)
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return []ReportRow{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ComputeReport(logs, branchCode), nil
+}
+
+// Aggregate logs per day, process and room of a branch. Rooms sharing name in a process are counted as one
+func ComputeReport(logs []HistoryLog, branchCode string) []ReportRow {
+	type roomKey struct {
+		Date    string
+		Process string
+		Room    string
+	}
+	type visit struct {
+		In, Out time.Time
+	}
+
+	visits := make(map[roomKey]map[string]*visit) // room -> patient -> visit
+	for _, log := range logs {
+		group := strings.ToLower(log.Group)
+
+		for _, process := range ProcessLibArr {
+			room, valid := AppConfig.BranchRoomSet(branchCode, process.Code).Room(group)
+			if !valid {
+				continue
+			}
+
+			key := roomKey{log.Date, process.Code, room.Name}
+			if visits[key] == nil {
+				visits[key] = make(map[string]*visit)
+			}
+			v, exist := visits[key][log.PatientID]
+			if !exist {
+				v = &visit{}
+				visits[key][log.PatientID] = v
+			}
+
+			switch log.Status {
+			case "I":
+				if v.In.IsZero() || log.Time.Before(v.In) {
+					v.In = log.Time
+				}
+			case "O":
+				if v.Out.IsZero() || log.Time.Before(v.Out) {
+					v.Out = log.Time
+				}
+			}
+		}
+	}
+
+	rows := []ReportRow{}
+	for key, patients := range visits {
+		row := ReportRow{Date: key.Date, Process: key.Process, Room: key.Room, PeakHour: -1}
+
+		var dwell []float64
+		var hours [24]int
+		for _, v := range patients {
+			if v.In.IsZero() {
+				continue
+			}
+
+			row.Served++
+			hours[v.In.Hour()]++
+			if !v.Out.IsZero() && v.Out.After(v.In) {
+				dwell = append(dwell, v.Out.Sub(v.In).Minutes())
+			}
+		}
+
+		sort.Float64s(dwell)
+		row.MedianDwell = percentile(dwell, 0.5)
+		row.P90Dwell = percentile(dwell, 0.9)
+		for hour, count := range hours {
+			if count > 0 && (row.PeakHour == -1 || count > hours[row.PeakHour]) {
+				row.PeakHour = hour
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	// Follow room order in config, so report reads like patient's journey
+	order := make(map[string]int)
+	for _, process := range ProcessLibArr {
+		for i, room := range AppConfig.BranchRoomSet(branchCode, process.Code).Rooms {
+			if _, exist := order[process.Code+room.Name]; !exist {
+				order[process.Code+room.Name] = i
+			}
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Date != rows[j].Date {
+			return rows[i].Date < rows[j].Date
+		}
+		if rows[i].Process != rows[j].Process {
+			return rows[i].Process < rows[j].Process
+		}
+		return order[rows[i].Process+rows[i].Room] < order[rows[j].Process+rows[j].Room]
+	})
+
+	return rows
+}
+
+// Header and values for export
+func (report Report) Table() [][]interface{} {
+	branchName, _ := AppConfig.getBranchInfo(report.Branch)
+
+	table := [][]interface{}{
+		{"Cabang", "Tanggal", "Proses", "Ruang", "Jumlah Pasien", "Median (menit)", "P90 (menit)", "Jam Tersibuk"},
+	}
+	for _, row := range report.Rows {
+		peakHour := "-"
+		if row.PeakHour != -1 {
+			peakHour = fmt.Sprintf("%02d:00", row.PeakHour)
+		}
+
+		table = append(table, []interface{}{
+			branchName, row.Date, ProcessLibMap[row.Process], row.Room, row.Served,
+			roundMinute(row.MedianDwell), roundMinute(row.P90Dwell), peakHour,
+		})
+	}
+
+	return table
+}
+
+func roundMinute(m float64) float64 {
+	return float64(int(m*10+0.5)) / 10
+}
+
+//========================================================================//
+// ** Handlers **//
+
+func InternalReportGetHandler(w http.ResponseWriter, r *http.Request) {
+	branchCode, ok := requireInternalSession(w, r, "report")
+	if !ok {
+		return
+	}
+	branchName, _ := AppConfig.getBranchInfo(branchCode)
+
+	list := listReports(branchCode)
+	running := false
+	for _, report := range list {
+		if report.Status == ReportRunning {
+			running = true
+		}
+	}
+
+	// Selected report to be shown, only if it belongs to logged branch
+	var selected interface{}
+	if report, exist := getReport(r.URL.Query().Get("id")); exist && report.Branch == branchCode {
+		selected = report
+	}
+
+	today := time.Now().Format("2006-01-02")
+	payload := map[string]interface{}{
+		"Branch":   branchName,
+		"Reports":  list,
+		"Selected": selected,
+		"Running":  running,
+		"From":     time.Now().AddDate(0, 0, -7).Format("2006-01-02"),
+		"To":       today,
+		"MaxDays":  maxReportDays,
+	}
+	if err := TemplateReport.Execute(w, payload); err != nil {
+		requestLogger(r, ErrorLogger).Printf("fail to execute template for report. %v\n", err)
+		httpError(w, r, "halaman gagal dimuat. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+	}
+}
+
+func InternalReportPostHandler(w http.ResponseWriter, r *http.Request) {
+	branchCode, ok := requireInternalSession(w, r, "report")
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		requestLogger(r, ErrorLogger).Printf("fail to parse input from report endpoint. %v\n", err)
+		httpError(w, r, "input gagal diproses. silahkan coba beberapa saat lagi.", http.StatusInternalServerError)
+		return
+	}
+
+	from, errFrom := time.Parse("2006-01-02", r.FormValue("from"))
+	to, errTo := time.Parse("2006-01-02", r.FormValue("to"))
+	if errFrom != nil || errTo != nil || to.Before(from) {
+		requestLogger(r, WarnLogger).Printf("kmn-internal: invalid report range. got: %v - %v", r.FormValue("from"), r.FormValue("to"))
+		httpError(w, r, "rentang tanggal tidak valid.", http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from) >= maxReportDays*24*time.Hour {
+		httpError(w, r, fmt.Sprintf("rentang tanggal maksimal %v hari.", maxReportDays), http.StatusBadRequest)
+		return
+	}
+
+	id := RequestReport(branchCode, from, to)
+	http.Redirect(w, r, "/kmn-internal/report?id="+id, http.StatusSeeOther)
+}
+
+func InternalReportExportHandler(w http.ResponseWriter, r *http.Request) {
+	branchCode, ok := requireInternalSession(w, r, "report")
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	report, exist := getReport(vars["id"])
+	if !exist || report.Branch != branchCode || report.Status != ReportDone {
+		httpError(w, r, "laporan tidak ditemukan.", http.StatusNotFound)
+		return
+	}
+
+	filename := "laporan_" + report.ID
+	switch vars["format"] {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".csv"))
+
+		writer := csv.NewWriter(w)
+		for _, row := range report.Table() {
+			record := make([]string, len(row))
+			for i, value := range row {
+				record[i] = fmt.Sprint(value)
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			requestLogger(r, ErrorLogger).Printf("report: fail to write csv %v. %v", report.ID, err)
+		}
+	case "xlsx":
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".xlsx"))
+
+		if err := WriteXLSX(w, "Laporan", report.Table()); err != nil {
+			requestLogger(r, ErrorLogger).Printf("report: fail to write xlsx %v. %v", report.ID, err)
+		}
+	default:
+		httpError(w, r, "format tidak didukung.", http.StatusNotFound)
+	}
+}
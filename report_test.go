@@ -0,0 +1,71 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeReport(t *testing.T) {
+	ctime := time.Date(0, 1, 1, 8, 0, 0, 0, time.UTC)
+	day := "2021-08-24"
+
+	logs := []HistoryLog{
+		{PatientID: "A001", Date: day, PatientLog: PatientLog{Group: "REG", Time: ctime, Status: "I"}},
+		{PatientID: "A001", Date: day, PatientLog: PatientLog{Group: "REG", Time: ctime.Add(time.Minute * 10), Status: "O"}},
+		{PatientID: "A002", Date: day, PatientLog: PatientLog{Group: "REG", Time: ctime.Add(time.Minute * 70), Status: "I"}},
+		{PatientID: "A002", Date: day, PatientLog: PatientLog{Group: "REG", Time: ctime.Add(time.Minute * 90), Status: "O"}},
+		{PatientID: "A003", Date: day, PatientLog: PatientLog{Group: "REG", Time: ctime.Add(time.Minute * 75), Status: "I"}},
+		{PatientID: "B001", Date: day, PatientLog: PatientLog{Group: "OT", Time: ctime, Status: "I"}},
+		{PatientID: "B001", Date: day, PatientLog: PatientLog{Group: "OT", Time: ctime.Add(time.Minute * 45), Status: "O"}},
+	}
+
+	get := ComputeReport(logs, "kmy")
+	want := []ReportRow{
+		{Date: day, Process: "opr", Room: "Ruang Tindakan", Served: 1, MedianDwell: 45, P90Dwell: 45, PeakHour: 8},
+		{Date: day, Process: "pol", Room: "Registrasi", Served: 3, MedianDwell: 15, P90Dwell: 19, PeakHour: 9},
+	}
+	if !reflect.DeepEqual(get, want) {
+		t.Errorf("wrong report: get %+v want %+v", get, want)
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteXLSX(&buf, "Laporan", [][]interface{}{
+		{"Ruang", "Jumlah"},
+		{"<Registrasi & Kasir>", 3},
+	})
+	if err != nil {
+		t.Fatalf("fail to write xlsx. %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("xlsx is not a valid zip. %v", err)
+	}
+
+	var sheet string
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, _ := f.Open()
+			b, _ := io.ReadAll(rc)
+			rc.Close()
+			sheet = string(b)
+		}
+	}
+
+	if !strings.Contains(sheet, `<c r="A2" t="inlineStr"><is><t>&lt;Registrasi &amp; Kasir&gt;</t></is></c>`) {
+		t.Errorf("string cell is not escaped. sheet: %v", sheet)
+	}
+	if !strings.Contains(sheet, `<c r="B2"><v>3</v></c>`) {
+		t.Errorf("number cell is not written. sheet: %v", sheet)
+	}
+	if xlsxColumn(27) != "AB" {
+		t.Errorf("wrong column name: get %v want AB", xlsxColumn(27))
+	}
+}
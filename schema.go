@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Mapping of queue log table in HIS database. Different HIS vendor (or version) may name the table and columns differently
+type SchemaData struct {
+	Table   string        `mapstructure:"table"`
+	Columns SchemaColumns `mapstructure:"columns"`
+	// Status value meaning patient entering (IN) and leaving (OUT) room
+	StatusIn  string `mapstructure:"status-in"`
+	StatusOut string `mapstructure:"status-out"`
+	// Go layout of date and time column, e.g. "2006-01-02" and "15:04:05"
+	DateFormat string `mapstructure:"date-format"`
+	TimeFormat string `mapstructure:"time-format"`
+}
+
+type SchemaColumns struct {
+	Group   string `mapstructure:"group"`
+	Room    string `mapstructure:"room"`
+	Time    string `mapstructure:"time"`
+	Status  string `mapstructure:"status"`
+	Branch  string `mapstructure:"branch"`
+	Patient string `mapstructure:"patient"`
+	Date    string `mapstructure:"date"`
+}
+
+// Returned by Verify when database doesn't match the mapping (as opposed to database being unreachable)
+var ErrSchemaMismatch = errors.New("database schema doesn't match config")
+
+// Table and column names are formatted into query, so only plain identifiers are accepted
+var identifierExp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,63}$`)
+
+// Fill missing value with the original HIS schema
+func (s *SchemaData) setDefault() {
+	defaults := []struct {
+		Dest  *string
+		Value string
+	}{
+		{&s.Table, "antri"},
+		{&s.Columns.Group, "kelompok"},
+		{&s.Columns.Room, "ruang"},
+		{&s.Columns.Time, "jam"},
+		{&s.Columns.Status, "status"},
+		{&s.Columns.Branch, "lokasi"},
+		{&s.Columns.Patient, "nomor"},
+		{&s.Columns.Date, "tanggal"},
+		{&s.StatusIn, "I"},
+		{&s.StatusOut, "O"},
+		{&s.DateFormat, "2006-01-02"},
+		{&s.TimeFormat, "15:04:05"},
+	}
+	for _, d := range defaults {
+		if *d.Dest == "" {
+			*d.Dest = d.Value
+		}
+	}
+}
+
+func (s SchemaData) columnList() []string {
+	c := s.Columns
+	return []string{c.Group, c.Room, c.Time, c.Status, c.Branch, c.Patient, c.Date}
+}
+
+func (s SchemaData) validate() error {
+	var invalid []string
+	for _, name := range append([]string{s.Table}, s.columnList()...) {
+		if !identifierExp.MatchString(name) {
+			invalid = append(invalid, fmt.Sprintf("%q", name))
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid table/column name in schema: %v (only letters, digits and underscore are allowed)", strings.Join(invalid, ", "))
+	}
+
+	if s.StatusIn == s.StatusOut {
+		return fmt.Errorf("status-in and status-out in schema must be different. got: %q", s.StatusIn)
+	}
+	return nil
+}
+
+// Identifier is validated when config is read, quoting is an additional safety
+func quoteIdentifier(name string) string {
+	return "`" + name + "`"
+}
+
+// Select clause with given columns, in the same order
+func (s SchemaData) selectFrom(columns ...string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quoteIdentifier(column)
+	}
+	return "SELECT DISTINCT " + strings.Join(quoted, ", ") + " FROM " + quoteIdentifier(s.Table)
+}
+
+// Columns: group, room, time, status. Params: branch, patient, date, status in, status out
+func (s SchemaData) queueLogsQuery() string {
+	c := s.Columns
+	return s.selectFrom(c.Group, c.Room, c.Time, c.Status) +
+		fmt.Sprintf(" WHERE (%s=? AND %s=? AND %s=? AND %s IN (?,?)) ORDER BY %s",
+			quoteIdentifier(c.Branch), quoteIdentifier(c.Patient), quoteIdentifier(c.Date), quoteIdentifier(c.Status), quoteIdentifier(c.Time))
+}
+
+// Columns: patient, group, room, time, status. Params: branch, date, status in, status out
+func (s SchemaData) branchLogsQuery() string {
+	c := s.Columns
+	return s.selectFrom(c.Patient, c.Group, c.Room, c.Time, c.Status) +
+		fmt.Sprintf(" WHERE (%s=? AND %s=? AND %s IN (?,?)) ORDER BY %s",
+			quoteIdentifier(c.Branch), quoteIdentifier(c.Date), quoteIdentifier(c.Status), quoteIdentifier(c.Time))
+}
+
+// Columns: patient, group, room, time, status. Params: branch, date, status in, status out, since
+// Note: comparison assumes time column is sortable in configured format (e.g. TIME column)
+func (s SchemaData) branchLogsSinceQuery() string {
+	c := s.Columns
+	return s.selectFrom(c.Patient, c.Group, c.Room, c.Time, c.Status) +
+		fmt.Sprintf(" WHERE (%s=? AND %s=? AND %s IN (?,?) AND %s>=?) ORDER BY %s",
+			quoteIdentifier(c.Branch), quoteIdentifier(c.Date), quoteIdentifier(c.Status), quoteIdentifier(c.Time), quoteIdentifier(c.Time))
+}
+
+// Columns: patient, date, group, room, time, status. Params: branch, from, to, status in, status out, groups...
+// Group codes are matched exactly (IN), patterns by LIKE
+func (s SchemaData) historyLogsQuery(groupCount, patternCount int) string {
+	c := s.Columns
+	var conditions []string
+	if groupCount > 0 {
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", groupCount), ",")
+		conditions = append(conditions, fmt.Sprintf("%s IN (%s)", quoteIdentifier(c.Group), placeholders))
+	}
+	for i := 0; i < patternCount; i++ {
+		conditions = append(conditions, quoteIdentifier(c.Group)+" LIKE ?")
+	}
+	group := strings.Join(conditions, " OR ")
+	if len(conditions) > 1 {
+		group = "(" + group + ")"
+	}
+
+	return s.selectFrom(c.Patient, c.Date, c.Group, c.Room, c.Time, c.Status) +
+		fmt.Sprintf(" WHERE (%s=? AND %s BETWEEN ? AND ? AND %s IN (?,?) AND %s) ORDER BY %s, %s",
+			quoteIdentifier(c.Branch), quoteIdentifier(c.Date), quoteIdentifier(c.Status), group,
+			quoteIdentifier(c.Date), quoteIdentifier(c.Time))
+}
+
+// Room pattern as LIKE pattern, e.g. POLI_* -> POLI\_%
+func likePattern(pattern string) string {
+	return strings.NewReplacer(`%`, `\%`, `_`, `\_`, "*", "%", "?", "_").Replace(pattern)
+}
+
+// Translate status value in database into I/O used by the app. Returns false for other status
+func (s SchemaData) normalizeStatus(status string) (string, bool) {
+	switch status {
+	case s.StatusIn:
+		return "I", true
+	case s.StatusOut:
+		return "O", true
+	default:
+		return status, false
+	}
+}
+
+// Check that mapped table and columns exist in database
+func (s SchemaData) Verify(db *sql.DB) error {
+	ctx, cancel := queryContext()
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", s.Table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(existing) == 0 {
+		return fmt.Errorf("%w: table %q configured in schema.table does not exist in database", ErrSchemaMismatch, s.Table)
+	}
+
+	var missing []string
+	for _, column := range s.columnList() {
+		if !existing[strings.ToLower(column)] {
+			missing = append(missing, column)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: column(s) %v configured in schema.columns do not exist in table %q", ErrSchemaMismatch, strings.Join(missing, ", "), s.Table)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	type Test struct {
+		name  string
+		table string
+		group string
+		valid bool
+	}
+
+	tests := []Test{
+		{name: "default", valid: true},
+		{name: "custom", table: "log_antrian", group: "Kelompok_2", valid: true},
+		{name: "injection", table: "antri; DROP TABLE antri", valid: false},
+		{name: "quote", group: "kelompok`", valid: false},
+		{name: "qualified", table: "his.antri", valid: false},
+	}
+
+	for _, tt := range tests {
+		schema := SchemaData{Table: tt.table, Columns: SchemaColumns{Group: tt.group}}
+		schema.setDefault()
+
+		err := schema.validate()
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("case %v: wrong validation: get %v want %v. err: %v", tt.name, valid, tt.valid, err)
+		}
+	}
+}
+
+func TestSchemaQuery(t *testing.T) {
+	schema := SchemaData{
+		Table:   "visit_log",
+		Columns: SchemaColumns{Group: "dept", Patient: "queue_no"},
+	}
+	schema.setDefault()
+
+	want := "SELECT DISTINCT `dept`, `ruang`, `jam`, `status` FROM `visit_log` WHERE (`lokasi`=? AND `queue_no`=? AND `tanggal`=? AND `status` IN (?,?)) ORDER BY `jam`"
+	if get := schema.queueLogsQuery(); get != want {
+		t.Errorf("wrong queue query:\nget  %v\nwant %v", get, want)
+	}
+
+	want = "SELECT DISTINCT `queue_no`, `tanggal`, `dept`, `ruang`, `jam`, `status` FROM `visit_log` WHERE (`lokasi`=? AND `tanggal` BETWEEN ? AND ? AND `status` IN (?,?) AND `dept` IN (?,?,?)) ORDER BY `tanggal`, `jam`"
+	if get := schema.historyLogsQuery(3, 0); get != want {
+		t.Errorf("wrong history query:\nget  %v\nwant %v", get, want)
+	}
+
+	want = "SELECT DISTINCT `queue_no`, `tanggal`, `dept`, `ruang`, `jam`, `status` FROM `visit_log` WHERE (`lokasi`=? AND `tanggal` BETWEEN ? AND ? AND `status` IN (?,?) AND (`dept` IN (?) OR `dept` LIKE ? OR `dept` LIKE ?)) ORDER BY `tanggal`, `jam`"
+	if get := schema.historyLogsQuery(1, 2); get != want {
+		t.Errorf("wrong history query with patterns:\nget  %v\nwant %v", get, want)
+	}
+
+	for pattern, want := range map[string]string{"POLI*": "POLI%", "POLI_?": `POLI\__`, "50%": `50\%`} {
+		if get := likePattern(pattern); get != want {
+			t.Errorf("case %v: wrong like pattern: get %v want %v", pattern, get, want)
+		}
+	}
+
+	if status, ok := schema.normalizeStatus("O"); !ok || status != "O" {
+		t.Errorf("wrong status: get %v %v", status, ok)
+	}
+	if _, ok := schema.normalizeStatus("X"); ok {
+		t.Errorf("unknown status must not be accepted")
+	}
+}
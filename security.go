@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const cspNonceKey contextKey = "csp-nonce"
+
+const cspReportPath = "/csp-report"
+
+// Content Security Policy is either enforced, only reported (to roll out safely) or not sent
+const (
+	CSPEnforce    = "enforce"
+	CSPReportOnly = "report-only"
+	CSPOff        = "off"
+)
+
+// Headers of one route group. Public pages and /kmn-internal are configured separately
+type SecurityPolicy struct {
+	CSPMode        string
+	FrameAncestors string // CSP source list, e.g. 'none' or 'self' https://portal.example
+	ReferrerPolicy string
+}
+
+// Browser features the app never uses
+const permissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=(), usb=()"
+
+// Directives counted by name in metrics, anything else is counted as "other"
+var cspDirectives = map[string]bool{
+	"default-src": true, "script-src": true, "script-src-elem": true, "script-src-attr": true,
+	"style-src": true, "style-src-elem": true, "style-src-attr": true, "img-src": true, "connect-src": true,
+	"font-src": true, "object-src": true, "base-uri": true, "form-action": true, "frame-ancestors": true,
+}
+
+func newCSPNonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return newRequestID() // still unpredictable enough for one response
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Nonce of inline scripts of the page, assigned by securityHeadersMiddleware
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceKey).(string)
+	return nonce
+}
+
+func securityPolicyOf(path string) SecurityPolicy {
+	if path == "/kmn-internal" || strings.HasPrefix(path, "/kmn-internal/") {
+		return AppConfig.SecurityInternal
+	}
+	return AppConfig.SecurityPublic
+}
+
+// Inline style attributes are used across templates, so styles can't be restricted by nonce
+func (p SecurityPolicy) contentSecurityPolicy(nonce string) string {
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-" + nonce + "'",
+		"style-src 'self' 'unsafe-inline'",
+		"img-src 'self' data:",
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors " + p.FrameAncestors,
+		"report-uri " + cspReportPath,
+	}, "; ")
+}
+
+// X-Frame-Options for browsers without frame-ancestors. It can't express a list of origins, so it's only sent for 'none' and 'self'
+func (p SecurityPolicy) frameOptions() string {
+	switch p.FrameAncestors {
+	case "'none'":
+		return "DENY"
+	case "'self'":
+		return "SAMEORIGIN"
+	}
+	return ""
+}
+
+func securityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := securityPolicyOf(r.URL.Path)
+		nonce := newCSPNonce()
+		header := w.Header()
+
+		switch policy.CSPMode {
+		case CSPEnforce:
+			header.Set("Content-Security-Policy", policy.contentSecurityPolicy(nonce))
+		case CSPReportOnly:
+			header.Set("Content-Security-Policy-Report-Only", policy.contentSecurityPolicy(nonce))
+		}
+		// Report-only policy doesn't block framing, so X-Frame-Options is always sent
+		if xfo := policy.frameOptions(); xfo != "" {
+			header.Set("X-Frame-Options", xfo)
+		}
+		header.Set("Referrer-Policy", policy.ReferrerPolicy)
+		header.Set("Permissions-Policy", permissionsPolicy)
+		header.Set("X-Content-Type-Options", "nosniff")
+		if r.TLS != nil && AppConfig.HSTSMaxAge > 0 {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(AppConfig.HSTSMaxAge.Seconds())))
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce)))
+	})
+}
+
+//========================================================================//
+// ** Violation Report **//
+
+type cspReport struct {
+	Body struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// Directive name used as metric label
+func (report cspReport) directive() string {
+	directive := report.Body.EffectiveDirective
+	if directive == "" {
+		directive = report.Body.ViolatedDirective
+	}
+	if fields := strings.Fields(directive); len(fields) > 0 && cspDirectives[fields[0]] {
+		return fields[0]
+	}
+	return "other"
+}
+
+// Reported URIs may carry search form (e.g. qinput of /search), so only scheme, host and path are kept
+func withoutQuery(uri string) string {
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		return uri[:i]
+	}
+	return uri
+}
+
+// Violations sent by browser (report-uri). Any page can send, so body is limited and only logged
+func CSPReportHandler(w http.ResponseWriter, r *http.Request) {
+	var report cspReport
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16*1024)).Decode(&report); err != nil {
+		httpError(w, r, "invalid report", http.StatusBadRequest)
+		return
+	}
+
+	directive := report.directive()
+	cspViolations.WithLabelValues(directive).Inc()
+	requestLogger(r, WarnLogger).With(Fields{
+		"directive":   directive,
+		"document":    withoutQuery(report.Body.DocumentURI),
+		"blocked":     withoutQuery(report.Body.BlockedURI),
+		"source":      withoutQuery(report.Body.SourceFile),
+		"line":        report.Body.LineNumber,
+		"disposition": report.Body.Disposition,
+	}).Printf("csp violation")
+
+	w.WriteHeader(http.StatusNoContent)
+}
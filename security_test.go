@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSecurityHeadersMiddleware(t *testing.T) {
+	public, internal, hsts := AppConfig.SecurityPublic, AppConfig.SecurityInternal, AppConfig.HSTSMaxAge
+	t.Cleanup(func() {
+		AppConfig.SecurityPublic, AppConfig.SecurityInternal, AppConfig.HSTSMaxAge = public, internal, hsts
+	})
+	AppConfig.SecurityPublic = SecurityPolicy{CSPMode: CSPEnforce, FrameAncestors: "'none'", ReferrerPolicy: "strict-origin-when-cross-origin"}
+	AppConfig.SecurityInternal = SecurityPolicy{CSPMode: CSPReportOnly, FrameAncestors: "'self'", ReferrerPolicy: "no-referrer"}
+	AppConfig.HSTSMaxAge = time.Hour
+
+	type Test struct {
+		name     string
+		path     string
+		tls      bool
+		csp      string // header name
+		xfo      string
+		referrer string
+		hsts     string
+	}
+
+	tests := []Test{
+		{name: "public", path: "/search", csp: "Content-Security-Policy", xfo: "DENY", referrer: "strict-origin-when-cross-origin"},
+		{name: "internal", path: "/kmn-internal/notification", csp: "Content-Security-Policy-Report-Only", xfo: "SAMEORIGIN", referrer: "no-referrer"},
+		{name: "internal login", path: "/kmn-internal", csp: "Content-Security-Policy-Report-Only", xfo: "SAMEORIGIN", referrer: "no-referrer"},
+		{name: "prefix only", path: "/kmn-internalx", csp: "Content-Security-Policy", xfo: "DENY", referrer: "strict-origin-when-cross-origin"},
+		{name: "tls", path: "/", tls: true, csp: "Content-Security-Policy", xfo: "DENY", referrer: "strict-origin-when-cross-origin", hsts: "max-age=3600"},
+	}
+
+	for _, tt := range tests {
+		var nonce string
+		handler := securityHeadersMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce = CSPNonce(r)
+		}))
+
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		header := w.Header()
+		csp := header.Get(tt.csp)
+		if csp == "" {
+			t.Errorf("case %v: missing %v header", tt.name, tt.csp)
+		}
+		if nonce == "" || !strings.Contains(csp, "'nonce-"+nonce+"'") {
+			t.Errorf("case %v: nonce of request isn't in policy. nonce: %v policy: %v", tt.name, nonce, csp)
+		}
+		if get := header.Get("X-Frame-Options"); get != tt.xfo {
+			t.Errorf("case %v: wrong X-Frame-Options: get %v want %v", tt.name, get, tt.xfo)
+		}
+		if get := header.Get("Referrer-Policy"); get != tt.referrer {
+			t.Errorf("case %v: wrong Referrer-Policy: get %v want %v", tt.name, get, tt.referrer)
+		}
+		if get := header.Get("Strict-Transport-Security"); get != tt.hsts {
+			t.Errorf("case %v: wrong Strict-Transport-Security: get %v want %v", tt.name, get, tt.hsts)
+		}
+		if header.Get("Permissions-Policy") == "" {
+			t.Errorf("case %v: missing Permissions-Policy header", tt.name)
+		}
+	}
+
+	// Nonce must not be reused across responses
+	first, second := newCSPNonce(), newCSPNonce()
+	if first == second {
+		t.Errorf("nonce is reused: %v", first)
+	}
+
+	// Frame ancestors list can't be expressed by X-Frame-Options
+	AppConfig.SecurityPublic = SecurityPolicy{CSPMode: CSPOff, FrameAncestors: "'self' https://portal.example", ReferrerPolicy: "no-referrer"}
+	w := httptest.NewRecorder()
+	securityHeadersMiddleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Header().Get("Content-Security-Policy") != "" || w.Header().Get("Content-Security-Policy-Report-Only") != "" {
+		t.Errorf("policy is sent while mode is off")
+	}
+	if get := w.Header().Get("X-Frame-Options"); get != "" {
+		t.Errorf("X-Frame-Options sent for origin list: %v", get)
+	}
+}
+
+func TestCSPReportHandler(t *testing.T) {
+	type Test struct {
+		name      string
+		body      string
+		code      int
+		directive string
+	}
+
+	tests := []Test{
+		{
+			name:      "script blocked",
+			body:      `{"csp-report": {"document-uri": "https://antrian.example/", "effective-directive": "script-src-elem", "violated-directive": "script-src", "blocked-uri": "inline"}}`,
+			code:      http.StatusNoContent,
+			directive: "script-src-elem",
+		},
+		{
+			name:      "older browser without effective directive",
+			body:      `{"csp-report": {"violated-directive": "img-src 'self' data:", "blocked-uri": "https://tracker.example"}}`,
+			code:      http.StatusNoContent,
+			directive: "img-src",
+		},
+		{
+			name:      "unknown directive",
+			body:      `{"csp-report": {"effective-directive": "<script>"}}`,
+			code:      http.StatusNoContent,
+			directive: "other",
+		},
+		{name: "not JSON", body: "hello", code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		var before float64
+		if tt.directive != "" {
+			before = testutil.ToFloat64(cspViolations.WithLabelValues(tt.directive))
+		}
+
+		w := httptest.NewRecorder()
+		CSPReportHandler(w, httptest.NewRequest("POST", cspReportPath, strings.NewReader(tt.body)))
+
+		if w.Code != tt.code {
+			t.Errorf("case %v: wrong status code: get %v want %v", tt.name, w.Code, tt.code)
+		}
+		if tt.directive != "" {
+			if get := testutil.ToFloat64(cspViolations.WithLabelValues(tt.directive)) - before; get != 1 {
+				t.Errorf("case %v: violation of %v counted %v times", tt.name, tt.directive, get)
+			}
+		}
+	}
+}
+
+func TestCSPReportWithoutQuery(t *testing.T) {
+	dir := t.TempDir()
+
+	settings := defaultLogSettings()
+	settings.File = filepath.Join(dir, "logs.txt")
+	if err := InitLogger(settings); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := InitLogger(defaultLogSettings()); err != nil {
+			log.Fatal("Fail to initialize logger!")
+		}
+	}()
+
+	body := `{"csp-report": {"document-uri": "https://antrian.example/search?branch=kbj&process=opr&qinput=A001#top", "effective-directive": "img-src", "blocked-uri": "https://tracker.example/p.gif?qinput=A001", "source-file": "https://antrian.example/search?qinput=A001"}}`
+	w := httptest.NewRecorder()
+	CSPReportHandler(w, httptest.NewRequest("POST", cspReportPath, strings.NewReader(body)))
+	CloseLogger()
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("wrong status code: get %v want %v", w.Code, http.StatusNoContent)
+	}
+	b, err := os.ReadFile(settings.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "qinput") || strings.Contains(string(b), "A001") {
+		t.Errorf("search query written to log: %s", b)
+	}
+	if !strings.Contains(string(b), "https://antrian.example/search") {
+		t.Errorf("document not logged: %s", b)
+	}
+}
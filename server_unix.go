@@ -0,0 +1,76 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Sent to running process to start new binary on the same socket (zero-downtime restart)
+var handoverSignal os.Signal = syscall.SIGUSR2
+
+// Listener passed by systemd socket activation (LISTEN_PID/LISTEN_FDS) or by previous process on handover (KMN_LISTEN_FD).
+// Returns nil if no socket is passed
+func inheritedListener() (net.Listener, error) {
+	fd := 0
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid == os.Getpid() {
+		if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n > 0 {
+			fd = 3 // first passed socket, see sd_listen_fds(3)
+		}
+	} else if handover := os.Getenv("KMN_LISTEN_FD"); handover != "" {
+		fd, _ = strconv.Atoi(handover)
+	}
+
+	// Don't pass them further to child process
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	os.Unsetenv("KMN_LISTEN_FD")
+
+	if fd == 0 {
+		return nil, nil
+	}
+
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// Start new instance of the binary, listening on the same socket. Caller shuts down afterwards
+func handoverListener(listener net.Listener) error {
+	tcp, ok := listener.(*net.TCPListener)
+	if !ok {
+		return errors.New("listener can't be passed to new process")
+	}
+	f, err := tcp.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	env := []string{}
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, "LISTEN_") && !strings.HasPrefix(e, "KMN_LISTEN_FD=") {
+			env = append(env, e)
+		}
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(env, "KMN_LISTEN_FD=3") // ExtraFiles[0] becomes fd 3
+	cmd.ExtraFiles = []*os.File{f}
+	return cmd.Start()
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// Socket passing isn't supported on windows
+var handoverSignal os.Signal
+
+func inheritedListener() (net.Listener, error) {
+	return nil, nil
+}
+
+func handoverListener(listener net.Listener) error {
+	return errors.New("handover isn't supported on windows")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Today's logs of a whole branch, kept in memory by background poller (snapshot mode)
+type branchSnapshot struct {
+	Date     string
+	Patients map[string][]PatientLog // keyed by queue number
+	LastSeen time.Time               // latest log time, next incremental fetch starts a look-back window before it
+	TakenAt  time.Time               // time of latest successful poll
+	FullAt   time.Time               // time of latest full reload
+
+	seen map[string]bool
+}
+
+var snapshots = struct {
+	sync.RWMutex
+	byBranch map[string]*branchSnapshot
+}{byBranch: make(map[string]*branchSnapshot)}
+
+func newBranchSnapshot(date string, now time.Time) *branchSnapshot {
+	return &branchSnapshot{
+		Date:     date,
+		Patients: make(map[string][]PatientLog),
+		TakenAt:  now,
+		FullAt:   now,
+		seen:     make(map[string]bool),
+	}
+}
+
+// Add logs not yet in snapshot. Returns number of added logs
+func (s *branchSnapshot) merge(patients map[string][]PatientLog) int {
+	n := 0
+	for id, logs := range patients {
+		for _, log := range logs {
+			key := strings.Join([]string{id, log.Group, log.Room, log.Time.Format("15:04:05"), log.Status}, "|")
+			if s.seen[key] {
+				continue
+			}
+			s.seen[key] = true
+
+			s.Patients[id] = append(s.Patients[id], log)
+			// log time is parsed on year 0, which is before zero time
+			if s.LastSeen.IsZero() || log.Time.After(s.LastSeen) {
+				s.LastSeen = log.Time
+			}
+			n++
+		}
+	}
+	return n
+}
+
+// Start of incremental fetch. Scans may be written late with earlier time, so window before latest log is fetched again
+// (already merged rows are skipped). Window doesn't go past midnight, as log time has no date
+func (s *branchSnapshot) fetchSince(lookback time.Duration) time.Time {
+	last := s.LastSeen
+	midnight := time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, last.Location())
+	if since := last.Add(-lookback); since.After(midnight) {
+		return since
+	}
+	return midnight
+}
+
+// Start one poller per branch. Snapshot is reloaded completely on day change and every SNAPSHOT_FULL_INTERVAL,
+// so corrected or deleted rows in HIS are eventually reflected
+func StartSnapshotPoller() {
+	for _, branch := range AppConfig.Branches {
+		go func(branch BranchData) {
+			for {
+				if err := pollSnapshot(branch, time.Now()); err != nil {
+					// keep serving previous snapshot, staleness is shown to patient
+					ErrorLogger.Printf("snapshot: sql query failed for %v(%v). %v", branch.ID, branch.Name, err)
+				}
+				time.Sleep(AppConfig.SnapshotInterval)
+			}
+		}(branch)
+	}
+	InfoLogger.Printf("snapshot mode enabled. polling every %v", AppConfig.SnapshotInterval)
+}
+
+func pollSnapshot(branch BranchData, now time.Time) error {
+	date := queueDate()
+
+	snapshots.RLock()
+	current := snapshots.byBranch[branch.Code]
+	snapshots.RUnlock()
+
+	full := current == nil || current.Date != date || !AppConfig.SnapshotIncremental ||
+		now.Sub(current.FullAt) >= AppConfig.SnapshotFullInterval
+	if full {
+		var patients map[string][]PatientLog
+		err := queryBranch(context.Background(), branch.Code, "branch", func(db *sql.DB) error {
+			var err error
+			patients, err = GetBranchQueueLogs(db, branch.ID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		snapshot := newBranchSnapshot(date, now)
+		snapshot.merge(patients)
+
+		snapshots.Lock()
+		snapshots.byBranch[branch.Code] = snapshot
+		snapshots.Unlock()
+		return nil
+	}
+
+	var patients map[string][]PatientLog
+	err := queryBranch(context.Background(), branch.Code, "branch-since", func(db *sql.DB) error {
+		var err error
+		patients, err = GetBranchQueueLogsSince(db, branch.ID, current.fetchSince(AppConfig.SnapshotLookback))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	snapshots.Lock()
+	current.merge(patients)
+	current.TakenAt = now
+	snapshots.Unlock()
+	return nil
+}
+
+// Copy of logs in branch snapshot (all patients if patientID is empty).
+// Returns false if snapshot isn't loaded yet (or is from previous day)
+func snapshotLogs(branchCode, patientID string) (map[string][]PatientLog, time.Time, bool) {
+	snapshots.RLock()
+	defer snapshots.RUnlock()
+
+	snapshot, exist := snapshots.byBranch[branchCode]
+	if !exist || snapshot.Date != queueDate() {
+		return nil, time.Time{}, false
+	}
+
+	patients := make(map[string][]PatientLog)
+	if patientID != "" {
+		if logs, exist := snapshot.Patients[patientID]; exist {
+			patients[patientID] = copyLogs(logs)
+		}
+		return patients, snapshot.TakenAt, true
+	}
+
+	for id, logs := range snapshot.Patients {
+		patients[id] = copyLogs(logs)
+	}
+	return patients, snapshot.TakenAt, true
+}
+
+// Logs of a patient and the time they were read from database
+func LookupQueueLogs(ctx context.Context, branchCode, branchID, patientID string) ([]PatientLog, time.Time, error) {
+	if AppConfig.SnapshotMode {
+		if patients, takenAt, ok := snapshotLogs(branchCode, patientID); ok {
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("source", "snapshot"))
+			logs, exist := patients[patientID]
+			if !exist {
+				return nil, takenAt, sql.ErrNoRows
+			}
+			return logs, takenAt, nil
+		}
+	}
+
+	return GetCachedQueueLogs(ctx, branchCode, branchID, patientID)
+}
+
+// Logs of all patients in a branch and the time they were read from database
+func LookupBranchQueueLogs(branchCode string) (map[string][]PatientLog, time.Time, error) {
+	if AppConfig.SnapshotMode {
+		if patients, takenAt, ok := snapshotLogs(branchCode, ""); ok {
+			return patients, takenAt, nil
+		}
+	}
+
+	_, branchID := AppConfig.getBranchInfo(branchCode)
+	var patients map[string][]PatientLog
+	err := queryBranch(context.Background(), branchCode, "branch", func(db *sql.DB) error {
+		var err error
+		patients, err = GetBranchQueueLogs(db, branchID)
+		return err
+	})
+	return patients, time.Now(), err
+}
+
+// Data older than SNAPSHOT_STALE_AFTER is flagged to patient
+func isStale(takenAt, now time.Time) bool {
+	return now.Sub(takenAt) > AppConfig.SnapshotStaleAfter
+}
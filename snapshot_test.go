@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestSnapshotMerge(t *testing.T) {
+	now := time.Date(2021, 8, 24, 10, 0, 0, 0, time.UTC)
+	ltime := time.Date(0, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	snapshot := newBranchSnapshot("2021-08-24", now)
+	added := snapshot.merge(map[string][]PatientLog{
+		"A001": {{Group: "PREOP", Time: ltime, Status: "I"}},
+		"A002": {{Group: "OT", Time: ltime.Add(time.Minute * 10), Status: "I"}},
+	})
+	if added != 2 || !snapshot.LastSeen.Equal(ltime.Add(time.Minute*10)) {
+		t.Fatalf("wrong full load: added %v, last seen %v", added, snapshot.LastSeen)
+	}
+
+	// Incremental fetch returns rows at last seen time again
+	added = snapshot.merge(map[string][]PatientLog{
+		"A002": {{Group: "OT", Time: ltime.Add(time.Minute * 10), Status: "I"}},
+		"A001": {{Group: "PREOP", Time: ltime.Add(time.Minute * 20), Status: "O"}},
+	})
+	if added != 1 {
+		t.Errorf("wrong incremental merge: added %v want 1", added)
+	}
+	if logs := snapshot.Patients["A001"]; len(logs) != 2 || logs[1].Status != "O" {
+		t.Errorf("wrong logs after merge: %+v", logs)
+	}
+	if logs := snapshot.Patients["A002"]; len(logs) != 1 {
+		t.Errorf("duplicate log merged: %+v", logs)
+	}
+}
+
+func TestSnapshotBackDatedLog(t *testing.T) {
+	now := time.Date(2021, 8, 24, 10, 0, 0, 0, time.UTC)
+	ltime := time.Date(0, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	snapshot := newBranchSnapshot("2021-08-24", now)
+	snapshot.merge(map[string][]PatientLog{
+		"A001": {{Group: "PREOP", Time: ltime, Status: "I"}},
+		"A002": {{Group: "OT", Time: ltime.Add(time.Minute * 10), Status: "I"}},
+	})
+
+	// Scan of 09:08 is written to HIS after the 09:10 one was polled
+	backDated := PatientLog{Group: "PREOP", Time: ltime.Add(time.Minute * 8), Status: "O"}
+	since := snapshot.fetchSince(time.Minute * 5)
+	if backDated.Time.Before(since) {
+		t.Fatalf("back-dated log at %v is before fetch start %v", backDated.Time.Format("15:04:05"), since.Format("15:04:05"))
+	}
+
+	// Look-back window returns already merged rows again
+	added := snapshot.merge(map[string][]PatientLog{
+		"A001": {backDated},
+		"A002": {{Group: "OT", Time: ltime.Add(time.Minute * 10), Status: "I"}},
+	})
+	if added != 1 {
+		t.Errorf("wrong merge of look-back window: added %v want 1", added)
+	}
+	if logs := snapshot.Patients["A001"]; len(logs) != 2 || logs[1].Status != "O" {
+		t.Errorf("back-dated log missing: %+v", logs)
+	}
+	if !snapshot.LastSeen.Equal(ltime.Add(time.Minute * 10)) {
+		t.Errorf("back-dated log moved last seen to %v", snapshot.LastSeen.Format("15:04:05"))
+	}
+
+	// Window stops at midnight
+	early := newBranchSnapshot("2021-08-24", now)
+	early.merge(map[string][]PatientLog{"A001": {{Group: "PREOP", Time: time.Date(0, 1, 1, 0, 2, 0, 0, time.UTC), Status: "I"}}})
+	if since := early.fetchSince(time.Minute * 5); since.Format("15:04:05") != "00:00:00" {
+		t.Errorf("wrong fetch start after midnight: %v", since.Format("15:04:05"))
+	}
+}
+
+func TestLookupQueueLogsSnapshot(t *testing.T) {
+	mode := AppConfig.SnapshotMode
+	t.Cleanup(func() { AppConfig.SnapshotMode = mode })
+	AppConfig.SnapshotMode = true
+
+	takenAt := time.Now().Add(-time.Minute * 5)
+	snapshot := newBranchSnapshot(queueDate(), takenAt)
+	snapshot.merge(map[string][]PatientLog{
+		"A001": {{Group: "PREOP", Time: time.Date(0, 1, 1, 9, 0, 0, 0, time.UTC), Status: "I"}},
+	})
+	snapshots.Lock()
+	snapshots.byBranch["kbj"] = snapshot
+	snapshots.Unlock()
+
+	logs, at, err := LookupQueueLogs(context.Background(), "kbj", "", "A001")
+	if err != nil || len(logs) != 1 || !at.Equal(takenAt) {
+		t.Errorf("wrong lookup: %+v %v %v", logs, at, err)
+	}
+	if _, _, err := LookupQueueLogs(context.Background(), "kbj", "", "A999"); err != sql.ErrNoRows {
+		t.Errorf("unknown patient must return no rows. got %v", err)
+	}
+	if !isStale(at, time.Now()) {
+		t.Errorf("snapshot of 5 minutes ago must be stale with %v threshold", AppConfig.SnapshotStaleAfter)
+	}
+}
@@ -0,0 +1,46 @@
+// Waiting-room board: rooms are re-rendered on every payload pushed by server
+function renderIDs(container, ids) {
+    container.innerHTML = "";
+    for (let i = 0; i < ids.length; i++) {
+        const span = document.createElement("span");
+        span.className = "board-id";
+        span.textContent = ids[i];
+        container.appendChild(span);
+    }
+}
+
+function renderBoard(payload) {
+    const cards = document.querySelectorAll("#rooms .board-card");
+    for (let i = 0; i < payload.rooms.length && i < cards.length; i++) {
+        renderIDs(cards[i].querySelector(".board-active"), payload.rooms[i].active);
+        renderIDs(cards[i].querySelector(".board-recent"), payload.rooms[i].recent);
+    }
+
+    // Notification is plain text, so it's set as text instead of parsed as markup
+    const notification = document.getElementById("branch-notification");
+    notification.innerHTML = "";
+    if (payload["branch-notification"]) {
+        const alert = document.createElement("div");
+        alert.className = "alert alert-warning container";
+        alert.textContent = payload["branch-notification"];
+        notification.appendChild(alert);
+    }
+
+    document.getElementById("last-updated").textContent = payload["last-updated"];
+}
+
+function connectBoard() {
+    // EventSource reconnects by itself when connection is dropped
+    const source = new EventSource(window.location.pathname + "/events");
+    source.onmessage = function (e) {
+        renderBoard(JSON.parse(e.data));
+    };
+}
+connectBoard();
+
+// Kiosk: enter full-screen on first touch/click, since browser only allows it from user gesture
+document.getElementById("board").addEventListener("click", function () {
+    if (!document.fullscreenElement && document.documentElement.requestFullscreen) {
+        document.documentElement.requestFullscreen();
+    }
+});
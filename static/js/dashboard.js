@@ -0,0 +1,118 @@
+// Staff dashboard: occupancy is re-rendered on every payload pushed by server
+function element(tag, className, text) {
+    const e = document.createElement(tag);
+    if (className) {
+        e.className = className;
+    }
+    if (text !== undefined) {
+        e.textContent = text;
+    }
+    return e;
+}
+
+function patientTable(patients, withRoom) {
+    if (patients.length === 0) {
+        return element("div", "text-muted small mb-2", "tidak ada pasien");
+    }
+
+    const table = element("table", "table table-sm table-striped");
+    const head = element("tr");
+    head.appendChild(element("th", "", "Antrian"));
+    if (withRoom) {
+        head.appendChild(element("th", "", "Ruang terakhir"));
+    }
+    head.appendChild(element("th", "", withRoom ? "Keluar pk." : "Masuk pk."));
+    head.appendChild(element("th", "", "Lama (menit)"));
+    table.appendChild(element("thead")).appendChild(head);
+
+    const body = table.appendChild(element("tbody"));
+    for (let i = 0; i < patients.length; i++) {
+        const row = element("tr");
+        row.appendChild(element("td", "font-weight-bold", patients[i].id));
+        if (withRoom) {
+            row.appendChild(element("td", "", patients[i].room));
+        }
+        row.appendChild(element("td", "", patients[i].since));
+        row.appendChild(element("td", "", patients[i].minutes));
+        body.appendChild(row);
+    }
+    return table;
+}
+
+function acknowledgeAlert(id, button) {
+    button.disabled = true;
+    fetch("/kmn-internal/alert/" + id + "/ack", { method: "POST" })
+        .then(function (response) {
+            if (!response.ok) {
+                throw new Error(response.statusText);
+            }
+            button.textContent = "sudah ditangani";
+        })
+        .catch(function (e) {
+            button.disabled = false;
+            console.log("ERROR: " + e);
+        });
+}
+
+function renderAlerts(alerts) {
+    const container = document.getElementById("alerts");
+    container.innerHTML = "";
+
+    for (let i = 0; i < alerts.length; i++) {
+        const alert = alerts[i];
+        const box = container.appendChild(element("div",
+            "alert d-flex justify-content-between align-items-center " + (alert.acknowledged ? "alert-secondary" : "alert-danger")));
+        box.appendChild(element("div", "",
+            alert["patient-id"] + " di " + alert.room + " sejak pk. " + alert.since +
+            " (" + alert.minutes + " menit, batas " + alert.threshold + " menit)"));
+
+        const button = box.appendChild(element("button", "btn btn-sm btn-outline-dark",
+            alert.acknowledged ? "sudah ditangani" : "tandai ditangani"));
+        button.type = "button";
+        button.disabled = alert.acknowledged;
+        button.addEventListener("click", function () {
+            acknowledgeAlert(alert.id, button);
+        });
+    }
+}
+
+function renderDashboard(payload) {
+    renderAlerts(payload.alerts);
+
+    const container = document.getElementById("occupancy");
+    container.innerHTML = "";
+
+    for (let i = 0; i < payload.processes.length; i++) {
+        const process = payload.processes[i];
+        container.appendChild(element("h3", "mt-4", process.name));
+
+        const row = container.appendChild(element("div", "row"));
+        for (let j = 0; j < process.rooms.length; j++) {
+            const room = process.rooms[j];
+            const col = row.appendChild(element("div", "col-md-4 mb-3"));
+            const card = col.appendChild(element("div", "card h-100"));
+            const cardBody = card.appendChild(element("div", "card-body"));
+            cardBody.appendChild(element("h5", "card-title", room.name + " (" + room.patients.length + ")"));
+            cardBody.appendChild(patientTable(room.patients, false));
+        }
+
+        container.appendChild(element("h5", "", "Menunggu ruang berikutnya (" + process.waiting.length + ")"));
+        container.appendChild(patientTable(process.waiting, true));
+    }
+
+    document.getElementById("last-updated").textContent = payload["last-updated"];
+}
+
+// Inline handlers are blocked by Content Security Policy
+document.getElementById("process").addEventListener("change", function () {
+    this.form.submit();
+});
+
+function connectDashboard() {
+    // EventSource reconnects by itself when connection is dropped
+    const source = new EventSource("/kmn-internal/dashboard/events" + window.location.search);
+    source.onmessage = function (e) {
+        renderDashboard(JSON.parse(e.data));
+    };
+}
+connectDashboard();
@@ -61,20 +61,28 @@ function QueueNumberInput() {
 }
 QueueNumberInput();
 
+// Only show processes offered by selected branch. Without branch, every process is shown
 function updateProcess() {
-    var e = document.getElementById("branch");
-    var selectedBranch = e.value;
+    var option = document.getElementById("branch").selectedOptions[0];
+    var offered = (option && option.value !== '') ? option.dataset.processes.split(" ") : null;
 
-    switch (selectedBranch){
-        case '':
-        case 'kbj':
-        case 'jsl':
-        case 'kmy':
-        case 'smg':
-            document.getElementById("opr").classList.remove("disabled");
-            break;
-        default:
-            document.getElementById("opr").classList.add("disabled");
+    var inputs = document.querySelectorAll('#search input[name="process"]');
+    var first = null;
+    for (let i = 0; i < inputs.length; i++) {
+        var shown = offered === null || offered.indexOf(inputs[i].value) !== -1;
+        inputs[i].disabled = !shown;
+        inputs[i].parentElement.classList.toggle("d-none", !shown);
+        if (shown && first === null)
+            first = inputs[i];
+    }
+
+    // Selected process isn't offered: select the first one that is
+    var checked = document.querySelector('#search input[name="process"]:checked');
+    if (first !== null && (checked === null || checked.disabled)) {
+        for (let i = 0; i < inputs.length; i++) {
+            inputs[i].checked = inputs[i] === first;
+            inputs[i].parentElement.classList.toggle("active", inputs[i] === first);
+        }
     }
 }
 
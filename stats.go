@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Duration (in minutes) a patient usually spends in a room, computed from previous days
+type DurationStat struct {
+	Samples int     `json:"samples"`
+	Low     float64 `json:"low"` // 25th percentile
+	Median  float64 `json:"median"`
+	High    float64 `json:"high"` // 75th percentile
+}
+
+type DurationStats struct {
+	UpdatedAt time.Time `json:"updated-at"`
+	// branch code -> group code (lowercase) -> statistic
+	Branches map[string]map[string]DurationStat `json:"branches"`
+}
+
+// Estimated completion range (HH:MM) shown in order-based display
+type CompletionEstimate struct {
+	From string
+	To   string
+}
+
+// Statistics are only computed for OPR, since families mostly want to know when the operation will be done
+const statsProcess = "opr"
+
+var durationStats = struct {
+	sync.RWMutex
+	data DurationStats
+}{}
+
+func getDurationStats(branchCode string) map[string]DurationStat {
+	durationStats.RLock()
+	defer durationStats.RUnlock()
+
+	return durationStats.data.Branches[branchCode]
+}
+
+// Load cached statistics from file, then periodically recompute them from database
+func StartDurationStatsJob() {
+	if err := loadDurationStats(); err != nil {
+		InfoLogger.Printf("stats: no usable cache in %v, statistics will be computed. %v", AppConfig.StatsFile, err)
+	}
+
+	go func() {
+		durationStats.RLock()
+		wait := AppConfig.StatsInterval - time.Since(durationStats.data.UpdatedAt)
+		durationStats.RUnlock()
+
+		for {
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+
+			updateDurationStats()
+			wait = AppConfig.StatsInterval
+		}
+	}()
+}
+
+func loadDurationStats() error {
+	b, err := os.ReadFile(AppConfig.StatsFile)
+	if err != nil {
+		return err
+	}
+
+	var data DurationStats
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	durationStats.Lock()
+	durationStats.data = data
+	durationStats.Unlock()
+
+	return nil
+}
+
+func saveDurationStats(data DurationStats) error {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(AppConfig.StatsFile, b, 0644)
+}
+
+func updateDurationStats() {
+	to := time.Now().AddDate(0, 0, -1) // today is still ongoing
+	from := to.AddDate(0, 0, -(AppConfig.StatsHistoryDays - 1))
+
+	durationStats.RLock()
+	data := DurationStats{
+		UpdatedAt: time.Now(),
+		Branches:  make(map[string]map[string]DurationStat),
+	}
+	for code, stats := range durationStats.data.Branches {
+		data.Branches[code] = stats
+	}
+	durationStats.RUnlock()
+
+	for _, branch := range AppConfig.Branches {
+		groups := AppConfig.BranchRoomSet(branch.Code, statsProcess).GroupCodes()
+		if len(groups) == 0 {
+			continue
+		}
+
+		var logs []HistoryLog
+		err := queryBranch(context.Background(), branch.Code, "stats", func(db *sql.DB) error {
+			var err error
+			logs, err = GetHistoryLogs(db, branch.ID, groups, from, to)
+			return err
+		})
+		if err != nil {
+			if err == sql.ErrNoRows {
+				InfoLogger.Printf("stats: no history found for %v(%v)", branch.ID, branch.Name)
+			} else {
+				// keep previous statistics of this branch
+				ErrorLogger.Printf("stats: sql query failed for %v(%v). %v", branch.ID, branch.Name, err)
+			}
+			continue
+		}
+
+		// Other codes of a room are measured as the room
+		set := AppConfig.BranchRoomSet(branch.Code, statsProcess)
+		for i := range logs {
+			if room, valid := set.Room(logs[i].Group); valid {
+				logs[i].Group = room.GroupCode
+			}
+		}
+
+		data.Branches[branch.Code] = ComputeDurationStats(logs)
+	}
+
+	durationStats.Lock()
+	durationStats.data = data
+	durationStats.Unlock()
+
+	if err := saveDurationStats(data); err != nil {
+		ErrorLogger.Printf("stats: fail to write cache %v. %v", AppConfig.StatsFile, err)
+	}
+	InfoLogger.Printf("stats: room duration statistics updated for %v branch(es)", len(data.Branches))
+}
+
+// Duration in a room is measured from first IN to first OUT, same as displayed to patient
+func ComputeDurationStats(logs []HistoryLog) map[string]DurationStat {
+	type visitKey struct {
+		Date      string
+		PatientID string
+		Group     string
+	}
+	type visit struct {
+		In, Out time.Time
+	}
+
+	visits := make(map[visitKey]*visit)
+	for _, log := range logs {
+		key := visitKey{log.Date, log.PatientID, strings.ToLower(log.Group)}
+		v, exist := visits[key]
+		if !exist {
+			v = &visit{}
+			visits[key] = v
+		}
+
+		switch log.Status {
+		case "I":
+			if v.In.IsZero() || log.Time.Before(v.In) {
+				v.In = log.Time
+			}
+		case "O":
+			if v.Out.IsZero() || log.Time.Before(v.Out) {
+				v.Out = log.Time
+			}
+		}
+	}
+
+	durations := make(map[string][]float64)
+	for key, v := range visits {
+		// Incomplete or mis-scanned visit can't be measured
+		if v.In.IsZero() || v.Out.IsZero() || !v.Out.After(v.In) {
+			continue
+		}
+
+		durations[key.Group] = append(durations[key.Group], v.Out.Sub(v.In).Minutes())
+	}
+
+	stats := make(map[string]DurationStat)
+	for group, d := range durations {
+		sort.Float64s(d)
+		stats[group] = DurationStat{
+			Samples: len(d),
+			Low:     percentile(d, 0.25),
+			Median:  percentile(d, 0.5),
+			High:    percentile(d, 0.75),
+		}
+	}
+
+	return stats
+}
+
+// Linear interpolation between closest ranks. Values must be sorted
+func percentile(sorted []float64, p float64) float64 {
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+
+	rank := p * float64(n-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// Estimate when the patient leaves the last room, based on the active room and statistics of remaining rooms.
+// Returns false if patient is already done or statistics are incomplete.
+func EstimateCompletion(logs []PatientLog, branchCode string, stats map[string]DurationStat, now time.Time) (CompletionEstimate, bool) {
+	set := AppConfig.BranchRoomSet(branchCode, statsProcess)
+	rooms := set.Rooms
+	n := len(rooms)
+	if n == 0 || stats == nil {
+		return CompletionEstimate{}, false
+	}
+
+	// Find the latest room in order, and its first IN/OUT
+	latest := -1
+	var in, out time.Time
+	for _, log := range logs {
+		room, valid := set.Room(log.Group)
+		if !valid || room.Order < 0 || room.Order >= n {
+			continue
+		}
+
+		if room.Order > latest {
+			latest = room.Order
+			in, out = time.Time{}, time.Time{}
+		}
+		if room.Order == latest {
+			if log.Status == "I" && (in.IsZero() || log.Time.Before(in)) {
+				in = log.Time
+			}
+			if log.Status == "O" && (out.IsZero() || log.Time.Before(out)) {
+				out = log.Time
+			}
+		}
+	}
+
+	if latest == -1 || (latest == n-1 && !out.IsZero()) {
+		return CompletionEstimate{}, false
+	}
+
+	var low, high float64
+	next := latest + 1
+	if out.IsZero() {
+		stat, exist := stats[strings.ToLower(rooms[latest].GroupCode)]
+		if !exist || stat.Samples == 0 {
+			return CompletionEstimate{}, false
+		}
+
+		elapsed := 0.0
+		if !in.IsZero() {
+			elapsed = now.Sub(onDate(in, now)).Minutes()
+		}
+
+		low = math.Max(stat.Low-elapsed, 0)
+		high = math.Max(stat.High-elapsed, 0)
+	}
+
+	for i := next; i < n; i++ {
+		stat, exist := stats[strings.ToLower(rooms[i].GroupCode)]
+		if !exist || stat.Samples == 0 {
+			return CompletionEstimate{}, false
+		}
+
+		low += stat.Low
+		high += stat.High
+	}
+
+	return CompletionEstimate{
+		From: now.Add(time.Duration(low * float64(time.Minute))).Format("15:04"),
+		To:   now.Add(time.Duration(high * float64(time.Minute))).Format("15:04"),
+	}, true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeDurationStats(t *testing.T) {
+	ctime := time.Date(0, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	logs := []HistoryLog{
+		// 10, 20, 30 and 40 minutes in OT
+		{PatientID: "A001", Date: "2021-08-23", PatientLog: PatientLog{Group: "OT", Time: ctime, Status: "I"}},
+		{PatientID: "A001", Date: "2021-08-23", PatientLog: PatientLog{Group: "OT", Time: ctime.Add(time.Minute * 10), Status: "O"}},
+		{PatientID: "A002", Date: "2021-08-23", PatientLog: PatientLog{Group: "OT", Time: ctime, Status: "I"}},
+		{PatientID: "A002", Date: "2021-08-23", PatientLog: PatientLog{Group: "OT", Time: ctime.Add(time.Minute * 20), Status: "O"}},
+		{PatientID: "A001", Date: "2021-08-24", PatientLog: PatientLog{Group: "OT", Time: ctime, Status: "I"}},
+		{PatientID: "A001", Date: "2021-08-24", PatientLog: PatientLog{Group: "OT", Time: ctime.Add(time.Minute * 30), Status: "O"}},
+		{PatientID: "A002", Date: "2021-08-24", PatientLog: PatientLog{Group: "ot", Time: ctime, Status: "I"}},
+		{PatientID: "A002", Date: "2021-08-24", PatientLog: PatientLog{Group: "ot", Time: ctime.Add(time.Minute * 40), Status: "O"}},
+		// duplicate OUT scan: first occurence is used
+		{PatientID: "A002", Date: "2021-08-24", PatientLog: PatientLog{Group: "ot", Time: ctime.Add(time.Minute * 45), Status: "O"}},
+		// no OUT: ignored
+		{PatientID: "A003", Date: "2021-08-24", PatientLog: PatientLog{Group: "PREPOST", Time: ctime, Status: "I"}},
+	}
+
+	stats := ComputeDurationStats(logs)
+
+	if _, exist := stats["prepost"]; exist {
+		t.Errorf("visit without OUT record should not be measured. got %v", stats["prepost"])
+	}
+
+	want := DurationStat{Samples: 4, Low: 17.5, Median: 25, High: 32.5}
+	if get := stats["ot"]; get != want {
+		t.Errorf("wrong OT statistic: get %v want %v", get, want)
+	}
+}
+
+func TestEstimateCompletion(t *testing.T) {
+	now := time.Date(2021, 8, 24, 10, 0, 0, 0, time.UTC)
+	ltime := time.Date(0, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	stats := map[string]DurationStat{
+		"preop":   {Samples: 10, Low: 10, Median: 15, High: 20},
+		"ot":      {Samples: 10, Low: 30, Median: 45, High: 60},
+		"prepost": {Samples: 10, Low: 20, Median: 30, High: 40},
+	}
+
+	type Test struct {
+		name  string
+		args  []PatientLog
+		stats map[string]DurationStat
+		want  CompletionEstimate
+		ok    bool
+	}
+
+	tests := []Test{
+		{
+			name: "in OT for 40 minutes",
+			args: []PatientLog{
+				{Group: "PREOP", Time: ltime.Add(-time.Minute * 60), Status: "I"},
+				{Group: "PREOP", Time: ltime.Add(-time.Minute * 45), Status: "O"},
+				{Group: "OT", Time: ltime.Add(-time.Minute * 40), Status: "I"},
+			},
+			stats: stats,
+			// OT: 0..20 minutes left, PREPOST: 20..40 minutes
+			want: CompletionEstimate{From: "10:20", To: "11:00"},
+			ok:   true,
+		}, {
+			name: "out of PREOP, waiting for OT",
+			args: []PatientLog{
+				{Group: "PREOP", Time: ltime.Add(-time.Minute * 20), Status: "I"},
+				{Group: "PREOP", Time: ltime.Add(-time.Minute * 5), Status: "O"},
+			},
+			stats: stats,
+			want:  CompletionEstimate{From: "10:50", To: "11:40"},
+			ok:    true,
+		}, {
+			name: "done",
+			args: []PatientLog{
+				{Group: "PREPOST", Time: ltime.Add(-time.Minute * 30), Status: "I"},
+				{Group: "PREPOST", Time: ltime.Add(-time.Minute * 5), Status: "O"},
+			},
+			stats: stats,
+			ok:    false,
+		}, {
+			name: "missing statistic",
+			args: []PatientLog{
+				{Group: "OT", Time: ltime.Add(-time.Minute * 10), Status: "I"},
+			},
+			stats: map[string]DurationStat{"ot": stats["ot"]},
+			ok:    false,
+		}, {
+			name: "no OPR data",
+			args: []PatientLog{
+				{Group: "REG", Time: ltime, Status: "I"},
+			},
+			stats: stats,
+			ok:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		get, ok := EstimateCompletion(tt.args, "kmy", tt.stats, now)
+		if ok != tt.ok {
+			t.Errorf("case %v: wrong availability: get %v want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if get != tt.want {
+			t.Errorf("case %v: wrong estimate: get %v want %v", tt.name, get, tt.want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+func (cfg *Config) TLSEnabled() bool {
+	return cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+}
+
+// Certificate that is reloaded when cert or key file changes, e.g. renewed by certbot, without restarting the app
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	sync.RWMutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func fileModTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Load cert and key again if either file is modified. Current certificate is kept if new one is invalid,
+// e.g. cert is already replaced but key isn't yet
+func (c *certReloader) reload() (bool, error) {
+	certTime, err := fileModTime(c.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyTime, err := fileModTime(c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.RLock()
+	unchanged := c.cert != nil && certTime.Equal(c.certTime) && keyTime.Equal(c.keyTime)
+	c.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	c.Lock()
+	c.cert = &cert
+	c.certTime = certTime
+	c.keyTime = keyTime
+	c.Unlock()
+	return true, nil
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cert, nil
+}
+
+// Check files every interval until stop is closed
+func (c *certReloader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reloaded, err := c.reload()
+			if err != nil {
+				ErrorLogger.Printf("tls: fail to reload certificate, keep using current one. %v", err)
+			} else if reloaded {
+				InfoLogger.Printf("tls: certificate reloaded from %v", c.certFile)
+			}
+		}
+	}
+}
+
+func tlsConfig(reloader *certReloader) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+}
+
+//========================================================================//
+// ** HTTP to HTTPS Redirect **//
+
+// Same host and path on HTTPS port
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		host = h
+	}
+	if AppConfig.Port != "443" {
+		host = net.JoinHostPort(host, AppConfig.Port)
+	}
+
+	// 308 keeps method and body of form submission
+	code := http.StatusMovedPermanently
+	if r.Method != "GET" && r.Method != "HEAD" {
+		code = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+}
+
+// Plain HTTP listener only redirecting to HTTPS. On handover, the port is still held by previous process for a while,
+// so binding is retried until it's released
+func serveRedirect(port string) *http.Server {
+	server := &http.Server{
+		Addr:         ":" + port,
+		Handler:      http.HandlerFunc(redirectToHTTPS),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+		ErrorLog:     ErrorLogger.StdLogger(),
+	}
+
+	go func() {
+		logged := false
+		for {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err == nil {
+				InfoLogger.Printf("redirecting http at %v to https", server.Addr)
+				if err := server.Serve(listener); err != http.ErrServerClosed {
+					ErrorLogger.Printf("redirect listener stopped. %v", err)
+				}
+				return
+			}
+			if !logged {
+				WarnLogger.Printf("fail to listen at %v for redirect, retrying. %v", server.Addr, err)
+				logged = true
+			}
+
+			select {
+			case <-shutdownStarted:
+				return
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+	return server
+}
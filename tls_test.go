@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Self-signed certificate with given serial number, written as PEM
+func writeTestCert(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "antrian.kmn.local"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	os.Chtimes(certFile, modTime, modTime)
+	os.Chtimes(keyFile, modTime, modTime)
+}
+
+func servedSerial(t *testing.T, reloader *certReloader) int64 {
+	cert, _ := reloader.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+
+	writeTestCert(t, certFile, keyFile, 1, start)
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial := servedSerial(t, reloader); serial != 1 {
+		t.Errorf("wrong initial certificate: serial %v", serial)
+	}
+
+	if reloaded, err := reloader.reload(); reloaded || err != nil {
+		t.Errorf("unchanged files reloaded: %v %v", reloaded, err)
+	}
+
+	// Renewed certificate
+	writeTestCert(t, certFile, keyFile, 2, start.Add(time.Minute))
+	if reloaded, err := reloader.reload(); !reloaded || err != nil {
+		t.Errorf("renewed certificate isn't reloaded: %v %v", reloaded, err)
+	}
+	if serial := servedSerial(t, reloader); serial != 2 {
+		t.Errorf("wrong renewed certificate: serial %v", serial)
+	}
+
+	// Key not written yet, current certificate is kept
+	os.WriteFile(keyFile, []byte("partial"), 0600)
+	if _, err := reloader.reload(); err == nil {
+		t.Errorf("invalid key is accepted")
+	}
+	if serial := servedSerial(t, reloader); serial != 2 {
+		t.Errorf("certificate replaced by invalid one: serial %v", serial)
+	}
+
+	if _, err := newCertReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Errorf("missing certificate is accepted")
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	type Test struct {
+		name     string
+		port     string
+		method   string
+		url      string
+		code     int
+		location string
+	}
+
+	tests := []Test{
+		{name: "default port", port: "443", method: "GET", url: "http://antrian.kmn.local/search?branch=kmn01", code: http.StatusMovedPermanently, location: "https://antrian.kmn.local/search?branch=kmn01"},
+		{name: "custom port", port: "8443", method: "GET", url: "http://antrian.kmn.local:8080/", code: http.StatusMovedPermanently, location: "https://antrian.kmn.local:8443/"},
+		{name: "login form", port: "443", method: "POST", url: "http://10.0.0.5/kmn-internal", code: http.StatusPermanentRedirect, location: "https://10.0.0.5/kmn-internal"},
+	}
+
+	defer func(port string) { AppConfig.Port = port }(AppConfig.Port)
+	for _, tt := range tests {
+		AppConfig.Port = tt.port
+		w := httptest.NewRecorder()
+		redirectToHTTPS(w, httptest.NewRequest(tt.method, tt.url, nil))
+
+		if w.Code != tt.code {
+			t.Errorf("case %v: wrong status code: get %v want %v", tt.name, w.Code, tt.code)
+		}
+		if get := w.Header().Get("Location"); get != tt.location {
+			t.Errorf("case %v: wrong location: get %v want %v", tt.name, get, tt.location)
+		}
+	}
+}
+
+func TestSessionCookieFlags(t *testing.T) {
+	AppConfig.TLSCertFile, AppConfig.TLSKeyFile = "", ""
+	if options := newSessionStore().Options; options.Secure || !options.HttpOnly {
+		t.Errorf("wrong cookie flags without TLS: %+v", options)
+	}
+
+	AppConfig.TLSCertFile, AppConfig.TLSKeyFile = "cert.pem", "key.pem"
+	defer func() { AppConfig.TLSCertFile, AppConfig.TLSKeyFile = "", "" }()
+	options := newSessionStore().Options
+	if !options.Secure || !options.HttpOnly || options.SameSite != http.SameSiteStrictMode {
+		t.Errorf("wrong cookie flags with TLS: %+v", options)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Uses global provider, which doesn't record anything until InitTracing sets an exporter
+var tracer = otel.Tracer("queueinfo")
+
+var tracerProvider *sdktrace.TracerProvider
+
+// Set up exporter from config.env. Exporter "none" keeps tracing disabled
+func InitTracing() error {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch AppConfig.TraceExporter {
+	case "none":
+		return nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpoint(AppConfig.TraceOTLPEndpoint),
+			otlptracehttp.WithInsecure(), // local collector
+		)
+	default:
+		return fmt.Errorf("unknown trace exporter %q", AppConfig.TraceExporter)
+	}
+	if err != nil {
+		return err
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		// Follow sampling decision of caller (e.g. proxy) if any
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(AppConfig.TraceSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String("queueinfo"),
+			semconv.ServiceVersionKey.String(Version),
+		)),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	InfoLogger.Printf("tracing enabled. exporter: %v, sample ratio: %v", AppConfig.TraceExporter, AppConfig.TraceSampleRatio)
+	return nil
+}
+
+// Export remaining spans. Used on shutdown
+func ShutdownTracing(ctx context.Context) {
+	if tracerProvider == nil {
+		return
+	}
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		ErrorLogger.Printf("fail to flush traces. %v", err)
+	}
+}
+
+// Root span of each request, continuing trace from incoming traceparent header
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := "not-found"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				name = template
+			}
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPRouteKey.String(name),
+				attribute.String("request-id", RequestID(r)),
+			),
+		)
+		defer span.End()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(recorder.status))
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+// End span, marking it failed if err isn't nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func branchProcessAttributes(branch, process string) trace.SpanStartOption {
+	return trace.WithAttributes(attribute.String("branch", branch), attribute.String("process", process))
+}
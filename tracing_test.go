@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	defer provider.Shutdown(context.Background())
+
+	router := mux.NewRouter()
+	router.HandleFunc("/board/{branch}/{process}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracer.Start(r.Context(), "board.build", branchProcessAttributes("kbj", "opr"))
+		span.End()
+	})
+	router.Use(tracingMiddleware)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/board/kbj/opr", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("wrong number of spans: get %v want 2", len(spans))
+	}
+
+	child, root := spans[0], spans[1]
+	if root.Name() != "GET /board/{branch}/{process}" {
+		t.Errorf("wrong root span name: %v", root.Name())
+	}
+	if child.Parent().SpanID() != root.SpanContext().SpanID() {
+		t.Errorf("stage span is not child of request span")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+const xssPayload = `<script>alert("xss")</script>`
+const xssAttrPayload = `" onmouseover="alert(1)`
+
+func TestTemplatesEscapeInjectedValues(t *testing.T) {
+	staticFiles, _ := fs.Sub(embeddedFiles, "static")
+	if err := loadAssets(staticFiles); err != nil {
+		t.Fatal(err)
+	}
+	if err := parseTemplates(embeddedFiles); err != nil {
+		t.Fatal(err)
+	}
+
+	type Test struct {
+		name     string
+		template *template.Template
+		payload  map[string]interface{}
+	}
+
+	tests := []Test{
+		{
+			name:     "home",
+			template: TemplateHome,
+			payload: map[string]interface{}{
+				"Branches":  []BranchOption{{Name: xssPayload, Code: xssAttrPayload, Processes: xssAttrPayload}},
+				"Processes": ProcessLibArr,
+			},
+		},
+		{
+			name:     "display",
+			template: TemplateDisplay,
+			payload: map[string]interface{}{
+				"Branch":             xssPayload,
+				"Id":                 xssPayload,
+				"Rooms":              []RoomDisplay{{Name: xssPayload, Time: "10:00:00", TimeOut: "-"}, {Name: xssPayload, Time: "10:05:00", TimeOut: "-"}},
+				"Estimate":           CompletionEstimate{},
+				"BranchNotification": xssPayload,
+				"RoomNotification":   xssPayload,
+			},
+		},
+		{
+			name:     "board",
+			template: TemplateBoard,
+			payload: map[string]interface{}{
+				"Branch":             xssPayload,
+				"Process":            xssPayload,
+				"Rooms":              []BoardRoom{{Name: xssPayload, Active: []string{xssPayload}}},
+				"BranchNotification": xssPayload,
+			},
+		},
+		{
+			name:     "edit notification",
+			template: TemplateEditNotification,
+			payload: map[string]interface{}{
+				"Branch":             xssPayload,
+				"BranchNotification": "</textarea>" + xssPayload,
+				"QueueNotification":  []Notification{{Code: "A", Text: xssAttrPayload}},
+				"ValidQueueCodeList": ValidQueueCodeList,
+				"CacheStats":         QueueCacheStats{},
+			},
+		},
+		{
+			name:     "error",
+			template: TemplateError,
+			payload:  map[string]interface{}{"Message": xssPayload, "RequestID": xssAttrPayload},
+		},
+	}
+
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := tt.template.Execute(&b, tt.payload); err != nil {
+			t.Errorf("case %v: fail to execute template. %v", tt.name, err)
+			continue
+		}
+		out := b.String()
+		if strings.Contains(out, xssPayload) || strings.Contains(out, "</textarea><script>") {
+			t.Errorf("case %v: script tag isn't escaped", tt.name)
+		}
+		if strings.Contains(out, xssAttrPayload) {
+			t.Errorf("case %v: attribute isn't escaped", tt.name)
+		}
+		if !strings.Contains(out, "&lt;script&gt;") {
+			t.Errorf("case %v: injected value isn't rendered as text", tt.name)
+		}
+	}
+
+	// Every template must be valid for contextual escaping, which is only checked on execute
+	for name, tmpl := range map[string]*template.Template{"login": TemplateLogin, "dashboard": TemplateDashboard, "report": TemplateReport} {
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, map[string]interface{}{}); err != nil {
+			t.Errorf("case %v: fail to execute template. %v", name, err)
+		}
+	}
+}
+
+func TestSanitizeNotificationInput(t *testing.T) {
+
+	tests := map[string]string{
+		"Poli tutup pk. 12:00":              "Poli tutup pk. 12:00",
+		"Jam <b>buka</b> & tutup":           "Jam buka & tutup",
+		"antrian < 10 menit":                "antrian < 10 menit",
+		xssPayload + "Halo":                 "Halo",
+		`<img src=x onerror="alert(1)">Hai`: "Hai",
+	}
+
+	for input, want := range tests {
+		if get := sanitizeNotificationInput(input); get != want {
+			t.Errorf("case %v: wrong text: get %q want %q", input, get, want)
+		}
+	}
+}
+
+func TestMigrateNotificationStore(t *testing.T) {
+	branch := AppConfig.Branches[0].Code
+	path := filepath.Join(t.TempDir(), "notification.json")
+	legacy := `{"` + branch + `": [{"code": "branch", "text": "Jam buka &amp; tutup"}, {"code": "A", "text": "&lt;b&gt;Poli&lt;/b&gt; &lt;script&gt;alert(1)&lt;/script&gt;"}]}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	notificationViper = viper.New()
+	notificationViper.SetConfigFile(path)
+	migrateNotificationStore()
+
+	branchText, roomText := GetNotification(branch, "A")
+	if branchText != "Jam buka & tutup" {
+		t.Errorf("wrong branch notification: get %q", branchText)
+	}
+	if roomText != "Poli " {
+		t.Errorf("wrong room notification: get %q", roomText)
+	}
+
+	// Already migrated text isn't decoded again
+	notificationViper.Set(branch, []Notification{{Code: "branch", Text: "tanda &amp; bukan entitas"}})
+	notificationViper.WriteConfig()
+	migrateNotificationStore()
+	if branchText, _ := GetNotification(branch, ""); branchText != "tanda &amp; bukan entitas" {
+		t.Errorf("migrated store decoded twice: get %q", branchText)
+	}
+}